@@ -3,12 +3,14 @@ package cmd
 import (
 	"bytes"
 	"fmt"
+	"strconv"
 	"text/template"
 
 	"github.com/txominpelu/fnd/log"
+	"github.com/txominpelu/fnd/search"
 )
 
-type renderOutput = func(map[string]string) string
+type renderOutput = func(search.Document) string
 
 func getRenderer(outputColumn string, outputTemplate string, logger *log.StandardLogger) renderOutput {
 	if outputTemplate == "" || outputColumn != "$" {
@@ -19,17 +21,58 @@ func getRenderer(outputColumn string, outputTemplate string, logger *log.Standar
 
 func renderByTemplate(outputTemplate string, logger *log.StandardLogger) renderOutput {
 	tmpl, err := template.New("test").Parse(outputTemplate)
-	logger.CheckError(err, fmt.Sprintf("while parsing output template: %s"))
-	return func(parsedLine map[string]string) string {
+	logger.CheckError(err, fmt.Sprintf("while parsing output template: %s", outputTemplate))
+	return func(doc search.Document) string {
 		var output bytes.Buffer
-		err := tmpl.Execute(&output, parsedLine)
-		logger.CheckError(err, fmt.Sprintf("while executing output template: %s"))
+		err := tmpl.Execute(&output, templateData(doc))
+		logger.CheckError(err, fmt.Sprintf("while executing output template: %s", outputTemplate))
 		return output.String()
 	}
 }
 
 func renderByColumn(outputColumn string) renderOutput {
-	return func(parsedLine map[string]string) string {
-		return parsedLine[outputColumn]
+	return func(doc search.Document) string {
+		return doc.ParsedLine[outputColumn]
 	}
 }
+
+// templateData gives templates the typed values of the fields so that
+// numbers can be compared ({{if gt .count 10}}) and objects navigated ({{.metadata.name}})
+func templateData(doc search.Document) map[string]interface{} {
+	data := map[string]interface{}{}
+	for k, v := range doc.ParsedLine {
+		data[k] = v
+	}
+	for k, v := range doc.Values {
+		data[k] = templateValue(v)
+	}
+	return data
+}
+
+// templateValue wraps the floats of a value, also inside objects and arrays, in templateFloat
+func templateValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		return templateFloat(v)
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for k, child := range v {
+			converted[k] = templateValue(child)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, child := range v {
+			converted[i] = templateValue(child)
+		}
+		return converted
+	}
+	return value
+}
+
+// templateFloat prints without exponent (1084300.5 instead of 1.0843005e+06)
+type templateFloat float64
+
+func (f templateFloat) String() string {
+	return strconv.FormatFloat(float64(f), 'f', -1, 64)
+}
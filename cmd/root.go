@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gdamore/tcell"
@@ -16,6 +17,7 @@ import (
 	"github.com/txominpelu/fnd/search"
 	"github.com/txominpelu/fnd/search/fuzzy"
 	"github.com/txominpelu/fnd/search/index"
+	"github.com/txominpelu/fnd/search/regex"
 )
 
 var RootCmd = &cobra.Command{
@@ -35,18 +37,46 @@ var logFile string
 var sorterName string
 var delimiter string
 var sorterColumn string
+var ngramMin int
+var ngramMax int
+var edgeNgrams bool
+var prefixSearch bool
+var maxEdits int
+var tokenizerNames []string
+var normalize bool
+var queryMode string
+var caseMode string
+var allFields bool
+var flattenArrays bool
+var csvHeader bool
+var sampleLines int
+var pattern string
 
 func init() {
-	RootCmd.PersistentFlags().StringVar(&lineFormat, "line_format", "plain", "fnd will parse the lines according to this format (plain,json,tabular)")
+	RootCmd.PersistentFlags().StringVar(&lineFormat, "line_format", "plain", "fnd will parse the lines according to this format (plain,json,tabular,csv,tsv,logfmt,regex,grep,columns)")
+	RootCmd.PersistentFlags().StringVar(&pattern, "pattern", "", "go regexp for --line_format regex, each named group is a column e.g '(?P<status>\\d+)'")
+	RootCmd.PersistentFlags().IntVar(&sampleLines, "sample_lines", 20, "first lines used to find the columns: the keys of logfmt input and the alignment of columns input. Input that comes slowly (tail -f) only waits for the first line")
+	RootCmd.PersistentFlags().BoolVar(&csvHeader, "header", true, "the first line of csv and tsv input is a header, otherwise columns are named by position (1, 2...)")
+	RootCmd.PersistentFlags().BoolVar(&flattenArrays, "flatten_arrays", false, "json fields inside arrays are named without the position (items.name instead of items[0].name) and hold the values of all the elements")
 	RootCmd.PersistentFlags().StringVar(&delimiter, "delimiter", " ", "delimiter for tabular parser (only the first char is considered)")
 	RootCmd.PersistentFlags().StringVar(&outputColumn, "output_column", "$", "column that will be used as output when picking an element ($ means it outputs the whole row)")
 	RootCmd.PersistentFlags().StringVar(&outputTemplate, "output_template", "", "golang template for the output: e.g {{.PID}} means return PID field")
-	RootCmd.PersistentFlags().StringVar(&searchType, "search_type", "fuzzy", "type of search (indexed, fuzzy). Indexed is faster for bigger input, fuzzy for finding more matches")
+	RootCmd.PersistentFlags().StringVar(&searchType, "search_type", "fuzzy", "type of search (indexed, fuzzy, regex). Indexed is faster for bigger input, fuzzy for finding more matches, regex takes each term as a go regexp")
 	RootCmd.PersistentFlags().StringVar(&logFile, "log_file", "", "errors will be logged to the given file")
 	RootCmd.PersistentFlags().StringSliceVar(&displayColumns, "display_columns", []string{}, "comma separated list of columns to display in order")
 	RootCmd.PersistentFlags().StringSliceVar(&hideColumns, "hide_columns", []string{}, "comma separated list of columns to hide")
-	RootCmd.PersistentFlags().StringVar(&sorterName, "sorter", "default", " sorter (index/default/bycolumn) ")
+	RootCmd.PersistentFlags().StringVar(&sorterName, "sorter", "default", " sorter (index/default/bycolumn/score/relevance) ")
 	RootCmd.PersistentFlags().StringVar(&sorterColumn, "sortby_column", "$", " column to use when using sorter bycolumn")
+	RootCmd.PersistentFlags().StringVar(&queryMode, "query_mode", "fzf", "how queries are parsed (fzf, sql). In fzf mode a query starting with '?' is parsed as sql")
+	RootCmd.PersistentFlags().IntVar(&ngramMin, "ngram_min", 2, "min length of the ngrams indexed for each word (search_type indexed)")
+	RootCmd.PersistentFlags().IntVar(&ngramMax, "ngram_max", 5, "max length of the ngrams indexed for each word, 0 disables ngrams (search_type indexed)")
+	RootCmd.PersistentFlags().BoolVar(&edgeNgrams, "edge_ngrams", false, "only index the ngrams at the start of each word (search_type indexed)")
+	RootCmd.PersistentFlags().BoolVar(&prefixSearch, "prefix_search", true, "match every word that starts with the query (search_type indexed). With --ngram_max 0 it replaces ngrams and uses less memory, but queries no longer match in the middle of words")
+	RootCmd.PersistentFlags().StringSliceVar(&tokenizerNames, "tokenizer", []string{"commandline"}, "how words are split for search_type indexed: a tokenizer for all the columns and/or column=tokenizer e.g path,content=words ("+strings.Join(index.TokenizerNames(), ", ")+")")
+	RootCmd.PersistentFlags().BoolVar(&normalize, "normalize", true, "ignore accents and compatibility forms when searching (cafe matches café, ｆｎｄ matches fnd)")
+	RootCmd.PersistentFlags().StringVar(&caseMode, "case", "smart", "case sensitivity of the queries (smart, ignore, respect). In smart mode the terms with an upper case letter are case sensitive")
+	RootCmd.PersistentFlags().BoolVar(&allFields, "all_fields", false, "terms without field search all the displayed columns instead of the whole line, as if they were *:term")
+	RootCmd.PersistentFlags().IntVar(&maxEdits, "max_edits", 0, "max typos (edits) allowed for a word to match, 0 disables typo tolerance (search_type indexed)")
 }
 
 func runRoot(cmd *cobra.Command, args []string) {
@@ -59,31 +89,51 @@ func runRoot(cmd *cobra.Command, args []string) {
 			fmt.Println(r)
 		}
 	}()
-	firstLine := ""
-	var scanner *bufio.Scanner
+	// the parser is created from the first lines (see search.FormatNameToParser)
+	firstLines := []string{}
+	linesToSample := 1
+	if lineFormat == "logfmt" || lineFormat == "columns" {
+		linesToSample = sampleLines
+	}
+	var lines chan string
 	comesFromStdin := stdinHasPipe()
 	if comesFromStdin {
-		scanner = bufio.NewScanner(os.Stdin)
-		scanner.Scan()
-		firstLine = scanner.Text()
+		scanner := bufio.NewScanner(os.Stdin)
+		scanner.Split(search.FormatSplitFunc(lineFormat))
+		lines = scanLines(scanner)
+		firstLines = readSample(lines, linesToSample)
 	}
 	logger := log.NewLogger(logFile)
 	searcher, err := getSearcher(searchType)
-	sorter := getSorter(searcher, sorterName, sorterColumn)
 	logger.CheckError(err, "when parsing search_type flag")
+	sorter, err := getSorter(searcher, sorterName, sorterColumn)
+	logger.CheckError(err, "when parsing sorter flag")
+	// every keystroke and refresh searches again, reuse what can be reused
+	searcher = search.NewCachedSearcher(searcher)
 
-	parser := search.FormatNameToParser(lineFormat, firstLine, displayColumns, hideColumns, logger, []rune(delimiter)[0])
-	if comesFromStdin && lineFormat != "tabular" {
-		searcher.AddDocument(search.ParseLine(parser, firstLine))
+	parserOptions := search.ParserOptions{
+		Headers:       displayColumns,
+		HideColumns:   hideColumns,
+		Delimiter:     []rune(delimiter)[0],
+		FlattenArrays: flattenArrays,
+		CsvHeader:     csvHeader,
+		Pattern:       pattern,
+	}
+	parser := search.FormatNameToParser(lineFormat, firstLines, parserOptions, logger)
+	if !normalize {
+		parser = parser.KeepAccents()
+	}
+	if parser.HasHeaderLine() && len(firstLines) > 0 {
+		firstLines = firstLines[1:]
+	}
+	for _, line := range firstLines {
+		searcher.AddDocument(search.ParseLine(parser, line))
 	}
 
 	go func() {
 		if comesFromStdin {
-			for scanner.Scan() {
-				searcher.AddDocument(search.ParseLine(parser, scanner.Text()))
-			}
-			if err := scanner.Err(); err != nil {
-				panic(fmt.Sprintf("Error: %s while reading stdin", err))
+			for line := range lines {
+				searcher.AddDocument(search.ParseLine(parser, line))
 			}
 		} else {
 			filesChannel := listFiles(logger)
@@ -95,51 +145,163 @@ func runRoot(cmd *cobra.Command, args []string) {
 
 	initialState := events.SearchState{Query: "", Selected: 0}
 	renderer := getRenderer(outputColumn, outputTemplate, logger)
-	printRows(s, initialState, &searcher, parser.Headers(), sorter)
-	handleEvents(&searcher, s, initialState, parser.Headers(), renderer, sorter)
+	if queryMode != "fzf" && queryMode != "sql" {
+		logger.CheckError(fmt.Errorf("query_mode should be one of (fzf / sql) it was '%s'", queryMode), "when parsing query_mode flag")
+	}
+	caseSensitivity, err := getCaseMode(caseMode)
+	logger.CheckError(err, "when parsing case flag")
+	queryParser := search.QueryParser{
+		SQL:         queryMode == "sql",
+		KeepAccents: !normalize,
+		Case:        caseSensitivity,
+		Columns:     parser.Headers(),
+		AllFields:   allFields,
+		Regex:       searchType == "regex",
+	}
+	printRows(s, initialState, events.SearchResults{}, &searcher, parser.Headers())
+	handleEvents(&searcher, s, initialState, queryParser, parser.Headers(), renderer, sorter)
 
 	s.Fini()
 }
 
-func getSorter(searcher search.TextSearcher, sorter string, sorterColumn string) search.Compare {
+func getSorter(searcher search.TextSearcher, sorter string, sorterColumn string) (search.Sorter, error) {
+	byLength := func(d1 int, d2 int) bool {
+		if len(searcher.GetDocById(d1).RawText) != len(searcher.GetDocById(d2).RawText) {
+			t1 := searcher.GetDocById(d1).RawText
+			t2 := searcher.GetDocById(d2).RawText
+			return len(t1) < len(t2)
+		} else {
+			return d1 < d2
+		}
+	}
 	if sorter == "index" {
-		return func(d1 int, d2 int) bool {
+		return search.StaticSorter(func(d1 int, d2 int) bool {
 			return d2 < d1
-		}
+		}), nil
 	} else if sorter == "bycolumn" {
-		return func(d1 int, d2 int) bool {
-			t1 := searcher.GetDocById(d1).ParsedLine[sorterColumn]
-			t2 := searcher.GetDocById(d2).ParsedLine[sorterColumn]
+		return search.StaticSorter(func(d1 int, d2 int) bool {
+			doc1, doc2 := searcher.GetDocById(d1), searcher.GetDocById(d2)
+			// numbers first and by value, then the rest by text
+			n1, ok1 := search.FieldNumber(doc1, sorterColumn)
+			n2, ok2 := search.FieldNumber(doc2, sorterColumn)
+			if ok1 != ok2 {
+				return ok1
+			}
+			if ok1 && n1 != n2 {
+				return n1 < n2
+			}
+			t1 := doc1.ParsedLine[sorterColumn]
+			t2 := doc2.ParsedLine[sorterColumn]
 			if t1 != t2 {
 				return t1 < t2
 			}
 			return d1 < d2
+		}), nil
+	} else if sorter == "score" {
+		scorer, ok := searcher.(search.Scorer)
+		if !ok {
+			return nil, fmt.Errorf("sorter 'score' is not supported by search_type '%s'", searchType)
 		}
-	} else {
-		return func(d1 int, d2 int) bool {
-			if len(searcher.GetDocById(d1).RawText) != len(searcher.GetDocById(d2).RawText) {
-				t1 := searcher.GetDocById(d1).RawText
-				t2 := searcher.GetDocById(d2).RawText
-				return len(t1) < len(t2)
-			} else {
-				return d1 < d2
-			}
+		return func(subQueries []search.SubQuery) search.Compare {
+			return byDescendingScore(func(docId int) float64 {
+				return scorer.Score(docId, subQueries)
+			}, byLength)
+		}, nil
+	} else if sorter == "relevance" {
+		ranker, ok := searcher.(search.Ranker)
+		if !ok {
+			return nil, fmt.Errorf("sorter 'relevance' is not supported by search_type '%s'", searchType)
 		}
-
+		return func(subQueries []search.SubQuery) search.Compare {
+			return byDescendingScore(ranker.Relevance(subQueries), byLength)
+		}, nil
+	} else {
+		return search.StaticSorter(byLength), nil
 	}
 
 }
 
+// byDescendingScore puts first the documents with the highest score, ties are sorted with tieBreak.
+// Scores are only valid for one query, each one is computed once
+func byDescendingScore(score func(docId int) float64, tieBreak search.Compare) search.Compare {
+	scores := map[int]float64{}
+	memoized := func(docId int) float64 {
+		if s, ok := scores[docId]; ok {
+			return s
+		}
+		s := score(docId)
+		scores[docId] = s
+		return s
+	}
+	return func(d1 int, d2 int) bool {
+		s1, s2 := memoized(d1), memoized(d2)
+		if s1 != s2 {
+			return s1 > s2
+		}
+		return tieBreak(d1, d2)
+	}
+}
+
 func getSearcher(searchType string) (search.TextSearcher, error) {
 	if searchType == "indexed" {
-		return index.NewIndexedLines(index.CommandLineTokenizer()), nil
+		options := index.Options{
+			NgramMin:    ngramMin,
+			NgramMax:    ngramMax,
+			EdgeNgrams:  edgeNgrams,
+			Prefix:      prefixSearch,
+			MaxEdits:    maxEdits,
+			KeepAccents: !normalize,
+		}
+		tokenizer, fieldTokenizers, err := getTokenizers(tokenizerNames)
+		if err != nil {
+			return nil, err
+		}
+		options.Tokenizers = fieldTokenizers
+		return index.NewIndexedLinesWithOptions(tokenizer, options), nil
 	} else if searchType == "fuzzy" {
 		return fuzzy.NewFuzzySearcher(), nil
+	} else if searchType == "regex" {
+		return regex.NewRegexSearcher(), nil
 	} else {
-		return nil, fmt.Errorf("search_type should be one of (indexed / fuzzy) it was '%s'", searchType)
+		return nil, fmt.Errorf("search_type should be one of (indexed / fuzzy / regex) it was '%s'", searchType)
 	}
 }
 
+func getCaseMode(name string) (search.CaseMode, error) {
+	switch name {
+	case "smart":
+		return search.CaseSmart, nil
+	case "ignore":
+		return search.CaseIgnore, nil
+	case "respect":
+		return search.CaseRespect, nil
+	}
+	return search.CaseIgnore, fmt.Errorf("case should be one of (smart / ignore / respect) it was '%s'", name)
+}
+
+// getTokenizers parses the tokenizer flag: 'name' is the tokenizer for all the columns
+// and 'column=name' the one of a column
+func getTokenizers(names []string) (index.Tokenizer, map[string]index.Tokenizer, error) {
+	tokenizer := index.CommandLineTokenizer()
+	fieldTokenizers := map[string]index.Tokenizer{}
+	for _, name := range names {
+		field := ""
+		if parts := strings.SplitN(name, "=", 2); len(parts) == 2 {
+			field, name = parts[0], parts[1]
+		}
+		t, err := index.TokenizerByName(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if field == "" {
+			tokenizer = t
+		} else {
+			fieldTokenizers[field] = t
+		}
+	}
+	return tokenizer, fieldTokenizers, nil
+}
+
 func listFiles(logger *log.StandardLogger) chan string {
 	out := make(chan string)
 	go func() {
@@ -156,6 +318,49 @@ func listFiles(logger *log.StandardLogger) chan string {
 	return out
 }
 
+// scanLines sends the lines of the scanner to the returned channel, it's closed at the end of the input
+func scanLines(scanner *bufio.Scanner) chan string {
+	out := make(chan string)
+	go func() {
+		for scanner.Scan() {
+			out <- scanner.Text()
+		}
+		if err := scanner.Err(); err != nil {
+			panic(fmt.Sprintf("Error: %s while reading stdin", err))
+		}
+		close(out)
+	}()
+	return out
+}
+
+// sampleTimeout is how long readSample waits for more lines after the first one
+const sampleTimeout = 200 * time.Millisecond
+
+// readSample returns up to n lines to create the parser from. It waits for the first line
+// but not for the rest, so that input that comes slowly (tail -f) is shown with the lines read until then
+func readSample(lines chan string, n int) []string {
+	sample := []string{}
+	if n < 1 {
+		return sample
+	}
+	if line, ok := <-lines; ok {
+		sample = append(sample, line)
+	}
+	timeout := time.After(sampleTimeout)
+	for len(sample) > 0 && len(sample) < n {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return sample
+			}
+			sample = append(sample, line)
+		case <-timeout:
+			return sample
+		}
+	}
+	return sample
+}
+
 func stdinHasPipe() bool {
 
 	fi, err := os.Stdin.Stat()
@@ -168,30 +373,28 @@ func stdinHasPipe() bool {
 	return true
 }
 
-func handleEvents(searcher *search.TextSearcher, s tcell.Screen, state events.SearchState, headers []string, renderer renderOutput, sorter search.Compare) {
-	eventChannel := events.NewEventsChannel(s, "", *searcher, sorter)
-	ticker := time.NewTicker(500 * time.Millisecond)
-	for {
-		select {
-		case <-ticker.C:
-			printRows(s, state, searcher, headers, sorter)
-		case ev := <-eventChannel:
-			state = ev.State()
-			switch ev.(type) {
-			case events.SearchStateChanged:
-				qChangedEv := ev.(events.SearchStateChanged)
-				printRows(s, qChangedEv.State(), searcher, headers, sorter)
-			case events.ScreenResizeEvent:
-				s.Sync()
-			case events.EntryFinalSelectEvent:
-				finalSelectEvt := ev.(events.EntryFinalSelectEvent)
-				fmt.Print(renderer(finalSelectEvt.State().Entry(*searcher, sorter).ParsedLine))
-				close(eventChannel)
-				return
-			case events.EscapeEvent:
-				close(eventChannel)
-				return
-			}
+func handleEvents(searcher *search.TextSearcher, s tcell.Screen, state events.SearchState, queryParser search.QueryParser, headers []string, renderer renderOutput, sorter search.Sorter) {
+	eventChannel := events.NewEventsChannel(s, state.Query, *searcher, queryParser, sorter)
+	// the last results received, they are shown until the ones of the current query arrive
+	results := events.SearchResults{}
+	for ev := range eventChannel {
+		state = ev.State()
+		switch ev.(type) {
+		case events.SearchStateChanged:
+			printRows(s, state, results, searcher, headers)
+		case events.SearchResultsEvent:
+			results = ev.(events.SearchResultsEvent).Results
+			printRows(s, state, results, searcher, headers)
+		case events.ScreenResizeEvent:
+			s.Sync()
+		case events.EntryFinalSelectEvent:
+			finalSelectEvt := ev.(events.EntryFinalSelectEvent)
+			fmt.Print(renderer(finalSelectEvt.Entry()))
+			close(eventChannel)
+			return
+		case events.EscapeEvent:
+			close(eventChannel)
+			return
 		}
 	}
 }
@@ -203,23 +406,38 @@ func handleEvents(searcher *search.TextSearcher, s tcell.Screen, state events.Se
 //	{{fi}}
 //  {{^lines}}
 
-func printRows(s tcell.Screen, state events.SearchState, searcher *search.TextSearcher, headers []string, sorter search.Compare) {
+// printRows shows the results, while the current query is being searched the previous results
+// are kept on screen with a searching indicator
+func printRows(s tcell.Screen, state events.SearchState, results events.SearchResults, searcher *search.TextSearcher, headers []string) {
 	s.Clear()
 	w, h := s.Size()
 	plain := tcell.StyleDefault.Normal()
 	bold := tcell.StyleDefault.Normal().Bold(true)
+	highlight := tcell.StyleDefault.Normal().Foreground(tcell.ColorGreen).Bold(true)
 
 	sc := screen.NewScreen(w, h)
 	sc.AppendRow(fmt.Sprintf("> %s", state.Query), 0, bold)
 
-	filtered := state.FilteredLines(*searcher, sorter)
-	sc.AppendRow(fmt.Sprintf("  %d/%d ", len(filtered), (*searcher).Count()), 0, bold)
+	filtered := results.Docs
+	if results.Query != state.Query {
+		sc.AppendRow(fmt.Sprintf("  %d/%d  searching…", len(filtered), (*searcher).Count()), 0, bold)
+	} else if results.Err != nil {
+		// an invalid query is shown but doesn't stop the user from typing
+		sc.AppendRow(fmt.Sprintf("  %d/%d  %s", len(filtered), (*searcher).Count(), results.Err), 0, bold)
+	} else {
+		sc.AppendRow(fmt.Sprintf("  %d/%d ", len(filtered), (*searcher).Count()), 0, bold)
+	}
 
 	t := screen.NewTable(headers)
-	for _, l := range filtered {
-		t.AddRow(l.ParsedLine)
+	for i, l := range filtered {
+		// only the rows that fit in the screen need highlighting
+		if i < h {
+			t.AddHighlightedRow(l.ParsedLine, (*searcher).MatchPositions(l, results.SubQueries))
+		} else {
+			t.AddRow(l.ParsedLine)
+		}
 	}
-	t.WriteToScreen(&sc, state.Selected, plain, bold, bold)
+	t.WriteToScreen(&sc, state.Selected, plain, bold, bold, highlight)
 	sc.PrintAll(s)
 
 	s.Sync()
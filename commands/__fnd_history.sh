@@ -6,9 +6,16 @@
 bind '"\C-r": "\C-x1\e^\er"'
 bind -x '"\C-x1": __fnd_history';
 
+# FND_HISTORY_MAX_EDITS=2 searches whole words allowing typos (kubctl finds kubectl) with the ones
+# that need fewer typos first, instead of the default fuzzy search (gco finds git checkout)
 __fnd_history ()
 {
-    __ehc "$({ echo "index command"; history; } | fnd --sorter index --line_format tabular --output_column "command")"
+    if [ -n "$FND_HISTORY_MAX_EDITS" ]
+    then
+        __ehc "$({ echo "index command"; history; } | fnd --sorter score --search_type indexed --max_edits "$FND_HISTORY_MAX_EDITS" --line_format tabular --output_column "command")"
+    else
+        __ehc "$({ echo "index command"; history; } | fnd --sorter index --line_format tabular --output_column "command")"
+    fi
 }
 
 __ehc()
@@ -1,8 +1,7 @@
 #!/usr/bin/env bash
 
-DIR="$( cd "$( dirname "${BASH_SOURCE[0]}" )" >/dev/null 2>&1 && pwd )"
-
-# Search for text with rg and open a file where a match happened
+# Search for text with rg and open a file at a matching line
+# (one entry per matching line, not per match as with rg --vimgrep)
 fnd-rg-edit() {
     set -e
     QUERY=$1
@@ -11,7 +10,7 @@ fnd-rg-edit() {
         echo >&2 "Should pass a text to search for with rg"
         return 1
     else
-        CHOICE=$(rg $QUERY --line-number | python3 $DIR/rg-to-fnd.py | fnd --line_format json \
+        CHOICE=$(rg $QUERY --line-number --column --no-heading | fnd --line_format grep \
             --search_type fuzzy \
             --output_template="+{{.line}} {{.file}}" \
             --display_columns="file,line,content" \
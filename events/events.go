@@ -1,53 +1,66 @@
 package events
 
 import (
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/gdamore/tcell"
 	"github.com/txominpelu/fnd/search"
 )
 
-func NewEventsChannel(s tcell.Screen, query string, searcher search.TextSearcher, sorter search.Compare) chan Event {
+// refreshInterval is how often the query is searched again while documents keep being added
+const refreshInterval = 500 * time.Millisecond
+
+// NewEventsChannel searches in the background so that typing is never blocked by a slow search.
+// A new query cancels the search of the previous one and only the results of the last query are sent.
+// It stops sending events after an EscapeEvent or an EntryFinalSelectEvent
+func NewEventsChannel(s tcell.Screen, query string, searcher search.TextSearcher, parser search.QueryParser, sorter search.Sorter) chan Event {
 	out := make(chan Event)
 	st := SearchState{query, 0}
 	notifier := StateChangeNotifier{currentState: st, notifyChan: out}
+	runner := newSearchRunner(searcher, parser, sorter)
+	screenEvents := pollEvents(s)
 
 	go func() {
+		ticker := time.NewTicker(refreshInterval)
+		defer ticker.Stop()
+		defer runner.stop()
+		runner.start(notifier.currentState)
 		for {
-			ev := s.PollEvent()
-			switch ev := ev.(type) {
-			case *tcell.EventKey:
-				switch ev.Key() {
-				case tcell.KeyEscape:
-					notifier.triggerEscape()
-					break
-				case tcell.KeyEnter:
-					notifier.triggerSelect()
-					break
-				case tcell.KeyUp:
-					if notifier.currentState.Selected+1 < len(notifier.currentState.FilteredLines(searcher, sorter)) {
-						notifier.setSelected(notifier.currentState.Selected + 1)
-					}
-				case tcell.KeyDown:
-					if notifier.currentState.Selected > 0 {
-						notifier.setSelected(notifier.currentState.Selected - 1)
-					}
-				case tcell.KeyDEL:
-					if len(notifier.currentState.Query) > 0 {
-						notifier.setQuery(notifier.currentState.Query[:len(notifier.currentState.Query)-1], searcher, sorter)
-					}
-				case tcell.KeyBS:
-					if len(notifier.currentState.Query) > 0 {
-						notifier.setQuery(notifier.currentState.Query[:len(notifier.currentState.Query)-1], searcher, sorter)
-					}
-				case tcell.KeyRune:
-					notifier.setQuery(fmt.Sprintf("%s%c", notifier.currentState.Query, ev.Rune()), searcher, sorter)
+			select {
+			case ev, ok := <-screenEvents:
+				if !ok || !notifier.handle(ev, runner) {
+					return
+				}
+			case outcome := <-runner.outcomes:
+				// results of a replaced query are dropped
+				if outcome.seq == runner.seq {
+					runner.pending = false
+					notifier.setResults(outcome.results)
+				}
+			case <-ticker.C:
+				if !runner.pending && searcher.Count() != notifier.results.Total {
+					runner.start(notifier.currentState)
 				}
-			case *tcell.EventResize:
-				notifier.triggerResize()
 			}
 		}
+	}()
+	return out
+}
 
+// pollEvents forwards the events of the screen to a channel, it's closed when the screen is finalized
+func pollEvents(s tcell.Screen) chan tcell.Event {
+	out := make(chan tcell.Event)
+	go func() {
+		for {
+			ev := s.PollEvent()
+			if ev == nil {
+				close(out)
+				return
+			}
+			out <- ev
+		}
 	}()
 	return out
 }
@@ -55,6 +68,44 @@ func NewEventsChannel(s tcell.Screen, query string, searcher search.TextSearcher
 type StateChangeNotifier struct {
 	notifyChan   chan Event
 	currentState SearchState
+	// results of the last query that finished searching
+	results SearchResults
+}
+
+// handle returns false once the user is done (escape or select)
+func (s *StateChangeNotifier) handle(ev tcell.Event, runner *searchRunner) bool {
+	switch ev := ev.(type) {
+	case *tcell.EventKey:
+		switch ev.Key() {
+		case tcell.KeyEscape:
+			s.triggerEscape()
+			return false
+		case tcell.KeyEnter:
+			s.triggerSelect()
+			return false
+		case tcell.KeyUp:
+			if s.currentState.Selected+1 < len(s.results.Docs) {
+				s.setSelected(s.currentState.Selected + 1)
+			}
+		case tcell.KeyDown:
+			if s.currentState.Selected > 0 {
+				s.setSelected(s.currentState.Selected - 1)
+			}
+		case tcell.KeyDEL:
+			if len(s.currentState.Query) > 0 {
+				s.setQuery(s.currentState.Query[:len(s.currentState.Query)-1], runner)
+			}
+		case tcell.KeyBS:
+			if len(s.currentState.Query) > 0 {
+				s.setQuery(s.currentState.Query[:len(s.currentState.Query)-1], runner)
+			}
+		case tcell.KeyRune:
+			s.setQuery(fmt.Sprintf("%s%c", s.currentState.Query, ev.Rune()), runner)
+		}
+	case *tcell.EventResize:
+		s.triggerResize()
+	}
+	return true
 }
 
 func (s *StateChangeNotifier) setSelected(selected int) {
@@ -66,18 +117,24 @@ func (s *StateChangeNotifier) setSelected(selected int) {
 	}
 }
 
-func (s *StateChangeNotifier) setQuery(query string, searcher search.TextSearcher, sorter search.Compare) {
+func (s *StateChangeNotifier) setQuery(query string, runner *searchRunner) {
 	if s.currentState.Query != query {
 		s.change(func(newState *SearchState) {
 			(*newState).Query = query
 		})
-		filteredEntries := s.currentState.FilteredLines(searcher, sorter)
-		if len(filteredEntries) <= s.currentState.Selected {
-			s.setSelected(0)
-		}
+		runner.start(s.currentState)
 	}
 }
 
+// setResults keeps the selection inside the new results
+func (s *StateChangeNotifier) setResults(results SearchResults) {
+	s.results = results
+	if len(results.Docs) <= s.currentState.Selected {
+		s.setSelected(0)
+	}
+	s.notifyChan <- SearchResultsEvent{state: s.currentState, Results: results}
+}
+
 func (s *StateChangeNotifier) triggerResize() {
 	s.notifyChan <- ScreenResizeEvent{s.currentState}
 }
@@ -86,8 +143,13 @@ func (s *StateChangeNotifier) triggerEscape() {
 	s.notifyChan <- EscapeEvent{s.currentState}
 }
 
+// the entry is taken from the results on screen
 func (s *StateChangeNotifier) triggerSelect() {
-	s.notifyChan <- EntryFinalSelectEvent{s.currentState}
+	entry := search.Document{}
+	if s.currentState.Selected < len(s.results.Docs) {
+		entry = s.results.Docs[s.currentState.Selected]
+	}
+	s.notifyChan <- EntryFinalSelectEvent{state: s.currentState, entry: entry}
 }
 
 func (s *StateChangeNotifier) change(updateState func(*SearchState)) {
@@ -113,16 +175,50 @@ type SearchState struct {
 	Selected int
 }
 
-func (state SearchState) FilteredLines(searcher search.TextSearcher, sorter search.Compare) []search.Document {
-	return search.SortDocuments(
-		searcher.FilterEntries(search.ParseQuery(state.Query)),
-		searcher,
-		sorter,
-	)
+// SubQueries parses the query and checks that the searcher accepts it
+func (state SearchState) SubQueries(searcher search.TextSearcher, parser search.QueryParser) ([]search.SubQuery, error) {
+	subQueries, err := parser.Parse(state.Query)
+	if err != nil {
+		return nil, err
+	}
+	if validator, ok := searcher.(search.QueryValidator); ok {
+		if err := validator.Validate(subQueries); err != nil {
+			return nil, err
+		}
+	}
+	return subQueries, nil
+}
+
+// FilteredLines returns the sorted documents that match the query, none if the query is not valid
+func (state SearchState) FilteredLines(searcher search.TextSearcher, parser search.QueryParser, sorter search.Sorter) []search.Document {
+	results, _ := state.Search(context.Background(), searcher, parser, sorter)
+	return results.Docs
 }
 
-func (state SearchState) Entry(searcher search.TextSearcher, sorter search.Compare) search.Document {
-	filtered := state.FilteredLines(searcher, sorter)
+// Search returns the sorted documents that match the query, an invalid query is reported in the results.
+// It returns ctx's error if ctx is done before the search finishes
+func (state SearchState) Search(ctx context.Context, searcher search.TextSearcher, parser search.QueryParser, sorter search.Sorter) (SearchResults, error) {
+	results := SearchResults{
+		Query: state.Query,
+		Docs:  []search.Document{},
+		Total: searcher.Count(),
+	}
+	subQueries, err := state.SubQueries(searcher, parser)
+	if err != nil {
+		results.Err = err
+		return results, nil
+	}
+	results.SubQueries = subQueries
+	docIds, err := search.FilterEntriesContext(ctx, searcher, subQueries)
+	if err != nil {
+		return SearchResults{}, err
+	}
+	results.Docs = search.SortDocuments(docIds, searcher, sorter(subQueries))
+	return results, ctx.Err()
+}
+
+func (state SearchState) Entry(searcher search.TextSearcher, parser search.QueryParser, sorter search.Sorter) search.Document {
+	filtered := state.FilteredLines(searcher, parser, sorter)
 	if state.Selected < len(filtered) {
 		return filtered[state.Selected]
 	} else {
@@ -145,12 +241,18 @@ func (e ScreenResizeEvent) State() SearchState {
 
 type EntryFinalSelectEvent struct {
 	state SearchState
+	entry search.Document
 }
 
 func (e EntryFinalSelectEvent) State() SearchState {
 	return e.state
 }
 
+// Entry is the selected document, empty if there were no results
+func (e EntryFinalSelectEvent) Entry() search.Document {
+	return e.entry
+}
+
 type EscapeEvent struct {
 	state SearchState
 }
@@ -167,3 +269,13 @@ type SearchStateChanged struct {
 func (e SearchStateChanged) State() SearchState {
 	return e.state
 }
+
+// SearchResultsEvent is sent when the search of the current query finishes
+type SearchResultsEvent struct {
+	state   SearchState
+	Results SearchResults
+}
+
+func (e SearchResultsEvent) State() SearchState {
+	return e.state
+}
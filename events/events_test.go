@@ -1,6 +1,7 @@
 package events
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"reflect"
@@ -12,93 +13,162 @@ import (
 	"github.com/txominpelu/fnd/search/index"
 )
 
-func TestQueryAndChangeSelect(t *testing.T) {
-	s, _ := tcell.NewScreen()
-	defer s.Fini()
+// session types keys and waits for the searches to finish so that the order of the events is deterministic
+type session struct {
+	s        tcell.Screen
+	events   chan Event
+	received []Event
+}
+
+func newSession(lines []string, wrap func(search.TextSearcher) search.TextSearcher) *session {
+	s := tcell.NewSimulationScreen("UTF-8")
 	encoding.Register()
 	if e := s.Init(); e != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", e)
 	}
-	lines := []string{"hello", "hello world", "hellod", "helloll"}
 	indexedLines := index.NewIndexedLines(
 		index.CommandLineTokenizer(),
 	)
 	for _, l := range lines {
 		indexedLines.AddDocument(search.ParseLine(search.PlainTextParser(), l))
 	}
-	eventChannel := NewEventsChannel(s, "", indexedLines)
-	go func() {
-		s.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'h', tcell.ModNone))
-		s.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'e', tcell.ModNone))
-		s.PostEvent(tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModNone))
-		s.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'l', tcell.ModNone))
-		s.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'l', tcell.ModNone))
-		s.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'o', tcell.ModNone))
-		s.PostEvent(tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModNone))
-		s.PostEvent(tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModNone))
-		s.PostEvent(tcell.NewEventKey(tcell.KeyESC, 0, tcell.ModNone))
-	}()
-	events := []Event{}
-	for ev := range eventChannel {
-		switch ev.(type) {
-		case EscapeEvent:
-			close(eventChannel)
+	eventChannel := NewEventsChannel(s, "", wrap(indexedLines), search.QueryParser{}, search.StaticSorter(func(d1 int, d2 int) bool { return d1 < d2 }))
+	se := &session{s: s, events: eventChannel}
+	se.waitResults("")
+	return se
+}
+
+func (se *session) press(key tcell.Key) {
+	se.s.PostEvent(tcell.NewEventKey(key, 0, tcell.ModNone))
+}
+
+// typeRune types without waiting for the results
+func (se *session) typeRune(r rune) {
+	se.s.PostEvent(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+}
+
+func (se *session) waitResults(query string) {
+	for ev := range se.events {
+		se.received = append(se.received, ev)
+		if r, ok := ev.(SearchResultsEvent); ok && r.Results.Query == query {
+			return
+		}
+	}
+}
+
+func (se *session) escape() {
+	se.press(tcell.KeyESC)
+	for ev := range se.events {
+		if _, ok := ev.(EscapeEvent); ok {
+			close(se.events)
 			break
-		default:
-			events = append(events, ev)
+		}
+		se.received = append(se.received, ev)
+	}
+	se.s.Fini()
+}
+
+func (se *session) lastState() SearchState {
+	for i := len(se.received) - 1; i >= 0; i-- {
+		if ev, ok := se.received[i].(SearchStateChanged); ok {
+			return ev.State()
 		}
 	}
+	return SearchState{}
+}
+
+func noWrap(searcher search.TextSearcher) search.TextSearcher {
+	return searcher
+}
+
+func TestQueryAndChangeSelect(t *testing.T) {
+	se := newSession([]string{"hello", "hello world", "hellod", "helloll"}, noWrap)
+	se.typeRune('h')
+	se.waitResults("h")
+	se.typeRune('e')
+	se.waitResults("he")
+	se.press(tcell.KeyUp)
+	se.typeRune('l')
+	se.waitResults("hel")
+	se.typeRune('l')
+	se.waitResults("hell")
+	se.typeRune('o')
+	se.waitResults("hello")
+	se.press(tcell.KeyUp)
+	se.press(tcell.KeyUp)
+	se.escape()
 	expected := SearchState{
 		Query:    "hello",
 		Selected: 1,
 	}
-	ev := events[len(events)-1].(SearchStateChanged)
-	if !reflect.DeepEqual(ev.State(), expected) {
-		t.Errorf("expected: '%v' got: '%v'\n", expected, ev.State())
+	if got := se.lastState(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected: '%v' got: '%v'\n", expected, got)
 	}
 
 }
 
 func TestSelectGoesZero(t *testing.T) {
-	s, _ := tcell.NewScreen()
-	defer s.Fini()
-	encoding.Register()
-	if e := s.Init(); e != nil {
-		fmt.Fprintf(os.Stderr, "%v\n", e)
-	}
-	lines := []string{"hello", "hellos", "hellod", "helloll"}
-	indexedLines := index.NewIndexedLines(
-		index.CommandLineTokenizer(),
-	)
-	for _, l := range lines {
-		indexedLines.AddDocument(search.ParseLine(search.PlainTextParser(), l))
-	}
-	eventChannel := NewEventsChannel(s, "", indexedLines)
-	go func() {
-		s.PostEvent(tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModNone))
-		s.PostEvent(tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModNone))
-		s.PostEvent(tcell.NewEventKey(tcell.KeyUp, 0, tcell.ModNone))
-		s.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'h', tcell.ModNone))
-		s.PostEvent(tcell.NewEventKey(tcell.KeyRune, 'o', tcell.ModNone))
-		s.PostEvent(tcell.NewEventKey(tcell.KeyESC, 0, tcell.ModNone))
-	}()
-	events := []Event{}
-	for ev := range eventChannel {
-		switch ev.(type) {
-		case EscapeEvent:
-			close(eventChannel)
-			break
-		default:
-			events = append(events, ev)
-		}
-	}
+	se := newSession([]string{"hello", "hellos", "hellod", "helloll"}, noWrap)
+	se.press(tcell.KeyUp)
+	se.press(tcell.KeyUp)
+	se.press(tcell.KeyUp)
+	se.typeRune('h')
+	se.waitResults("h")
+	se.typeRune('o')
+	se.waitResults("ho")
+	se.escape()
 	expected := SearchState{
 		Query:    "ho",
 		Selected: 0,
 	}
-	ev := events[len(events)-1].(SearchStateChanged)
-	if !reflect.DeepEqual(ev.State(), expected) {
-		t.Errorf("expected: '%v' got: '%v'\n", expected, ev.State())
+	if got := se.lastState(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected: '%v' got: '%v'\n", expected, got)
+	}
+
+}
+
+// blockingSearcher never finishes searching "slow" until the search is cancelled
+type blockingSearcher struct {
+	search.TextSearcher
+	cancelled chan bool
+}
+
+func (b blockingSearcher) FilterEntriesContext(ctx context.Context, subQueries []search.SubQuery) ([]int, error) {
+	if len(subQueries) == 1 && subQueries[0].Query == "slow" {
+		<-ctx.Done()
+		b.cancelled <- true
+		return nil, ctx.Err()
 	}
+	return b.TextSearcher.FilterEntries(subQueries), nil
+}
 
+func TestNewQueryCancelsSearch(t *testing.T) {
+	cancelled := make(chan bool, 1)
+	se := newSession([]string{"slow", "slower"}, func(searcher search.TextSearcher) search.TextSearcher {
+		return blockingSearcher{TextSearcher: searcher, cancelled: cancelled}
+	})
+	for _, r := range "slower" {
+		se.typeRune(r)
+	}
+	se.waitResults("slower")
+	se.escape()
+	if !<-cancelled {
+		t.Errorf("Expected the search of 'slow' to be cancelled")
+	}
+	got := []string{}
+	for _, ev := range se.received {
+		r, ok := ev.(SearchResultsEvent)
+		if ok && r.Results.Query == "slow" {
+			t.Errorf("Expected the results of 'slow' to be dropped")
+		}
+		if ok && r.Results.Query == "slower" {
+			for _, d := range r.Results.Docs {
+				got = append(got, d.RawText)
+			}
+		}
+	}
+	expected := []string{"slower"}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, got)
+	}
 }
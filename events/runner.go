@@ -0,0 +1,71 @@
+package events
+
+import (
+	"context"
+
+	"github.com/txominpelu/fnd/search"
+)
+
+// SearchResults are the sorted documents matching a query
+type SearchResults struct {
+	Query      string
+	SubQueries []search.SubQuery
+	Docs       []search.Document
+	// Total is the number of documents in the searcher when the search started
+	Total int
+	// Err is set when the query is not valid
+	Err error
+}
+
+// searchRunner runs one search at a time in the background,
+// starting a search cancels the one that was running
+type searchRunner struct {
+	searcher search.TextSearcher
+	parser   search.QueryParser
+	sorter   search.Sorter
+	outcomes chan searchOutcome
+	cancel   context.CancelFunc
+	// seq identifies the last search started, pending is true until its results are received
+	seq     int
+	pending bool
+}
+
+type searchOutcome struct {
+	seq     int
+	results SearchResults
+}
+
+func newSearchRunner(searcher search.TextSearcher, parser search.QueryParser, sorter search.Sorter) *searchRunner {
+	return &searchRunner{
+		searcher: searcher,
+		parser:   parser,
+		sorter:   sorter,
+		outcomes: make(chan searchOutcome),
+	}
+}
+
+func (r *searchRunner) start(state SearchState) {
+	r.stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	r.seq++
+	r.pending = true
+	seq := r.seq
+	go func() {
+		results, err := state.Search(ctx, r.searcher, r.parser, r.sorter)
+		if err != nil {
+			// cancelled, a newer search replaces it
+			return
+		}
+		select {
+		case r.outcomes <- searchOutcome{seq: seq, results: results}:
+		case <-ctx.Done():
+		}
+	}()
+}
+
+func (r *searchRunner) stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/txominpelu/fnd
 
-go 1.18
+go 1.26.0
 
 require (
 	github.com/gdamore/tcell v1.2.0
@@ -8,7 +8,7 @@ require (
 	github.com/spf13/cobra v0.0.5
 )
 
-require golang.org/x/text v0.3.0 // indirect
+require golang.org/x/text v0.42.0
 
 require (
 	github.com/gdamore/encoding v1.0.0 // indirect
@@ -17,6 +17,5 @@ require (
 	github.com/lucasb-eyer/go-colorful v1.0.2 // indirect
 	github.com/mattn/go-runewidth v0.0.4 // indirect
 	github.com/spf13/pflag v1.0.3 // indirect
-	golang.org/x/exp v0.0.0-20220328175248-053ad81199eb
 	golang.org/x/sys v0.0.0-20220330033206-e17cdc41300f // indirect
 )
@@ -32,6 +32,15 @@ func (row *Row) writeString(s string, x int, style tcell.Style) {
 	}
 }
 
+func (row *Row) writeStyledRunes(runes []rune, styles []tcell.Style, x int) {
+	for i, char := range runes {
+		if i+x >= row.width {
+			break
+		}
+		row.writeRune(char, i+x, styles[i])
+	}
+}
+
 func newRow(width int) Row {
 	return Row{
 		width:  width,
@@ -64,6 +73,13 @@ func (sc *Screen) AppendRow(s string, x int, style tcell.Style) {
 	sc.rows = append(sc.rows, r)
 }
 
+// appends a row where every rune has its own style
+func (sc *Screen) AppendStyledRow(runes []rune, styles []tcell.Style, x int) {
+	r := newRow(sc.width)
+	r.writeStyledRunes(runes, styles, x)
+	sc.rows = append(sc.rows, r)
+}
+
 func (sc *Screen) PrintAll(s tcell.Screen) {
 	for y, r := range sc.rows {
 		for x, b := range r.blocks {
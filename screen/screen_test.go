@@ -2,9 +2,11 @@ package screen
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/gdamore/tcell"
+	"github.com/txominpelu/fnd/search"
 )
 
 func TestPrintBasic(t *testing.T) {
@@ -25,7 +27,7 @@ func TestPrintBasic(t *testing.T) {
 	plain := tcell.StyleDefault
 	blink := tcell.StyleDefault.Foreground(tcell.ColorSilver)
 	bold := tcell.StyleDefault.Bold(true)
-	table.WriteToScreen(&sc, 0, plain, blink, bold)
+	table.WriteToScreen(&sc, 0, plain, blink, bold, bold)
 	fmt.Println("Screen:")
 	fmt.Print(sc.toString())
 	//if !reflect.DeepEqual(ev.State(), expected) {
@@ -33,3 +35,42 @@ func TestPrintBasic(t *testing.T) {
 	//}
 
 }
+
+func TestHighlight(t *testing.T) {
+	table := NewTable([]string{"$"})
+	table.AddHighlightedRow(
+		map[string]string{"$": "hello"},
+		search.MatchPositions{"$": []search.Range{{Start: 1, End: 3}}},
+	)
+	sc := NewScreen(10, 3)
+	plain := tcell.StyleDefault
+	bold := tcell.StyleDefault.Bold(true)
+	highlight := tcell.StyleDefault.Foreground(tcell.ColorGreen)
+	table.WriteToScreen(&sc, 1, plain, bold, bold, highlight)
+	expected := []tcell.Style{plain, plain, plain, highlight, highlight, plain, plain}
+	got := []tcell.Style{}
+	for _, b := range sc.rows[0].blocks[:len(expected)] {
+		got = append(got, b.style)
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected: '%v' got: '%v'\n", expected, got)
+	}
+}
+
+func TestMultilineValues(t *testing.T) {
+	parser := search.FormatNameToParser("csv", []string{"name,city"}, search.ParserOptions{Delimiter: ',', CsvHeader: true}, nil)
+	doc := search.ParseLine(parser, "\"Smith, John\",\"New\nYork\"")
+	table := NewTable([]string{"name", "city"})
+	table.AddHighlightedRow(doc.ParsedLine, search.MatchPositions{"city": []search.Range{{Start: 4, End: 8}}})
+	runes, highlighted := table.buildRow(doc.ParsedLine, table.highlights[0], map[string]int{"name": 20, "city": 20})
+	expected := "Smith, John          New↵York             "
+	if string(runes) != expected {
+		t.Errorf("expected: '%v' got: '%v'\n", expected, string(runes))
+	}
+	if !highlighted[25] || !highlighted[28] || highlighted[24] || highlighted[29] {
+		t.Errorf("expected York to be highlighted: '%v'\n", highlighted)
+	}
+	if doc.ParsedLine["city"] != "New\nYork" {
+		t.Errorf("expected: '%v' got: '%v'\n", "New\nYork", doc.ParsedLine["city"])
+	}
+}
@@ -1,27 +1,36 @@
 package screen
 
 import (
-	"fmt"
 	"strings"
+	"unicode"
 	"unicode/utf8"
 
 	"github.com/gdamore/tcell"
+	"github.com/txominpelu/fnd/search"
 )
 
 type Table struct {
-	columns []string
-	rows    []map[string]string
+	columns    []string
+	rows       []map[string]string
+	highlights []search.MatchPositions
 }
 
 func NewTable(headers []string) Table {
 	return Table{
-		columns: headers,
-		rows:    []map[string]string{},
+		columns:    headers,
+		rows:       []map[string]string{},
+		highlights: []search.MatchPositions{},
 	}
 }
 
 func (t *Table) AddRow(row map[string]string) {
+	t.AddHighlightedRow(row, search.MatchPositions{})
+}
+
+// AddHighlightedRow adds a row where the given ranges of each column will be highlighted
+func (t *Table) AddHighlightedRow(row map[string]string, highlights search.MatchPositions) {
 	t.rows = append(t.rows, row)
+	t.highlights = append(t.highlights, highlights)
 }
 
 func (t Table) computeWidths(width int) map[string]int {
@@ -48,18 +57,31 @@ func (t Table) maxWidth(column string) int {
 	return max
 }
 
-func (t Table) WriteToScreen(sc *Screen, selected int, plainStyle tcell.Style, selectedStyle tcell.Style, boldStyle tcell.Style) {
+func (t Table) WriteToScreen(sc *Screen, selected int, plainStyle tcell.Style, selectedStyle tcell.Style, boldStyle tcell.Style, highlightStyle tcell.Style) {
 	// leftPaddingLength is require to have a space when listing elements to do '>' for the selected one
 	leftPaddingLength := 2
 	//TODO: allow trimming if all columns together get out of screen
 	var columnToWidth map[string]int = t.computeWidths(sc.width - leftPaddingLength)
 	for i, row := range t.rows {
-		rowString := t.buildRowString(row, columnToWidth)
+		var highlights search.MatchPositions
+		if i < len(t.highlights) {
+			highlights = t.highlights[i]
+		}
+		runes, highlighted := t.buildRow(row, highlights, columnToWidth)
+		prefix := []rune("  ")
+		style := plainStyle
 		if i == selected {
-			sc.AppendRow(fmt.Sprintf("> %s", rowString), 0, selectedStyle)
-		} else {
-			sc.AppendRow(fmt.Sprintf("  %s", rowString), 0, plainStyle)
+			prefix = []rune("> ")
+			style = selectedStyle
 		}
+		styles := make([]tcell.Style, len(prefix)+len(runes))
+		for j := range styles {
+			styles[j] = style
+			if j >= len(prefix) && highlighted[j-len(prefix)] {
+				styles[j] = highlightStyle
+			}
+		}
+		sc.AppendStyledRow(append(prefix, runes...), styles, 0)
 		// 4 = headers line + query line + counter line + initial line
 		if i+4 >= sc.height {
 			break
@@ -70,26 +92,52 @@ func (t Table) WriteToScreen(sc *Screen, selected int, plainStyle tcell.Style, s
 		columns[column] = column
 	}
 	headersString := t.buildRowString(columns, columnToWidth)
-	sc.AppendRow(fmt.Sprintf("  %s", headersString), 0, boldStyle)
+	sc.AppendRow("  "+headersString, 0, boldStyle)
 }
 
 func (t Table) buildRowString(row map[string]string, columnToWidth map[string]int) string {
-	sBuilder := strings.Builder{}
+	runes, _ := t.buildRow(row, search.MatchPositions{}, columnToWidth)
+	return string(runes)
+}
+
+// buildRow lays out the columns of the row and tells for each rune whether it's highlighted
+func (t Table) buildRow(row map[string]string, highlights search.MatchPositions, columnToWidth map[string]int) ([]rune, []bool) {
+	runes := []rune{}
+	highlighted := []bool{}
 	for _, column := range t.columns {
 		i := 0
-		sValueBuilder := strings.Builder{}
+		val := []rune{}
 		for _, char := range row[column] {
 			i = i + 1
 			if i >= columnToWidth[column] {
 				break
 			}
-			sValueBuilder.WriteRune(char)
+			val = append(val, displayRune(char))
 		}
-		val := sValueBuilder.String()
-		fieldValue := strings.Join([]string{val, strings.Repeat(" ", computeRightPaddingLen(val, columnToWidth[column]))}, "")
-		sBuilder.WriteString(fieldValue)
+		mask := make([]bool, len(val))
+		for _, r := range highlights[column] {
+			for j := r.Start; j < r.End && j < len(val); j++ {
+				mask[j] = true
+			}
+		}
+		padding := computeRightPaddingLen(string(val), columnToWidth[column])
+		runes = append(append(runes, val...), []rune(strings.Repeat(" ", padding))...)
+		highlighted = append(append(highlighted, mask...), make([]bool, padding)...)
+	}
+	return runes, highlighted
+}
+
+// displayRune keeps each value in its row: new lines inside values (e.g quoted csv) are shown as ↵
+// and other control runes as spaces. The value itself (for output) is kept as it is and
+// every rune is replaced by one so that the highlighted ranges still match
+func displayRune(r rune) rune {
+	if r == '\n' {
+		return '↵'
+	}
+	if unicode.IsControl(r) {
+		return ' '
 	}
-	return sBuilder.String()
+	return r
 }
 
 func computeRightPaddingLen(val string, columnWidth int) int {
@@ -0,0 +1,157 @@
+package search
+
+import (
+	"context"
+	"sync"
+)
+
+// cacheSize is the number of queries whose results are kept
+const cacheSize = 16
+
+// CachedSearcher wraps a searcher and keeps the results of the last queries.
+// If the searcher is a Refiner a query that narrows a cached one only filters the cached results
+// and documents added after a query was cached are filtered and appended to its results.
+// Otherwise a cached query is only reused while no document is added.
+type CachedSearcher struct {
+	searcher TextSearcher
+	mutex    sync.Mutex
+	// most recently used last
+	entries []cacheEntry
+}
+
+type cacheEntry struct {
+	key        string
+	subQueries []SubQuery
+	results    []int
+	// the results only account for the docIds below count
+	count int
+}
+
+func NewCachedSearcher(searcher TextSearcher) *CachedSearcher {
+	return &CachedSearcher{
+		searcher: searcher,
+		entries:  []cacheEntry{},
+	}
+}
+
+func (c *CachedSearcher) AddDocument(document Document) {
+	c.searcher.AddDocument(document)
+}
+
+func (c *CachedSearcher) GetDocById(docId int) Document {
+	return c.searcher.GetDocById(docId)
+}
+
+func (c *CachedSearcher) Count() int {
+	return c.searcher.Count()
+}
+
+func (c *CachedSearcher) MatchPositions(document Document, subQueries []SubQuery) MatchPositions {
+	return c.searcher.MatchPositions(document, subQueries)
+}
+
+// Validate delegates to the wrapped searcher if it's a QueryValidator
+func (c *CachedSearcher) Validate(subQueries []SubQuery) error {
+	if validator, ok := c.searcher.(QueryValidator); ok {
+		return validator.Validate(subQueries)
+	}
+	return nil
+}
+
+// FilterEntries returns a copy of the results, callers are free to sort them
+func (c *CachedSearcher) FilterEntries(subQueries []SubQuery) []int {
+	results, _ := c.FilterEntriesContext(context.Background(), subQueries)
+	return results
+}
+
+// FilterEntriesContext is FilterEntries but stops as soon as ctx is done if the searcher supports it.
+// Results of a cancelled query are not cached
+func (c *CachedSearcher) FilterEntriesContext(ctx context.Context, subQueries []SubQuery) ([]int, error) {
+	if len(subQueries) == 0 {
+		return FilterEntriesContext(ctx, c.searcher, subQueries)
+	}
+	key := Key(subQueries)
+	refiner, isRefiner := c.searcher.(Refiner)
+	// read before filtering, documents added meanwhile will be filtered next time
+	count := c.searcher.Count()
+	var results []int
+	var err error
+	if entry, ok := c.find(key); ok && entry.count == count {
+		results = entry.results
+	} else if ok && isRefiner {
+		var added []int
+		added, err = refiner.FilterCandidates(ctx, idsBetween(entry.count, count), subQueries)
+		results = append(copyIds(entry.results), added...)
+	} else if previous, ok := c.findNarrowed(subQueries, refiner); ok && isRefiner {
+		candidates := append(copyIds(previous.results), idsBetween(previous.count, count)...)
+		results, err = refiner.FilterCandidates(ctx, candidates, subQueries)
+	} else if isRefiner {
+		results, err = refiner.FilterCandidates(ctx, idsBetween(0, count), subQueries)
+	} else {
+		results, err = FilterEntriesContext(ctx, c.searcher, subQueries)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.store(cacheEntry{key: key, subQueries: subQueries, results: results, count: count})
+	return copyIds(results), nil
+}
+
+func (c *CachedSearcher) find(key string) (cacheEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, e := range c.entries {
+		if e.key == key {
+			return e, true
+		}
+	}
+	return cacheEntry{}, false
+}
+
+// findNarrowed returns the cached query with the fewest results that the subqueries narrow
+func (c *CachedSearcher) findNarrowed(subQueries []SubQuery, refiner Refiner) (cacheEntry, bool) {
+	if refiner == nil {
+		return cacheEntry{}, false
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	best := cacheEntry{}
+	found := false
+	for _, e := range c.entries {
+		if refiner.Narrows(e.subQueries, subQueries) && (!found || len(e.results) < len(best.results)) {
+			best = e
+			found = true
+		}
+	}
+	return best, found
+}
+
+func (c *CachedSearcher) store(entry cacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entries := []cacheEntry{}
+	for _, e := range c.entries {
+		if e.key != entry.key {
+			entries = append(entries, e)
+		}
+	}
+	entries = append(entries, entry)
+	if len(entries) > cacheSize {
+		entries = entries[len(entries)-cacheSize:]
+	}
+	c.entries = entries
+}
+
+func idsBetween(start int, end int) []int {
+	ids := make([]int, 0, end-start)
+	for id := start; id < end; id++ {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func copyIds(ids []int) []int {
+	c := make([]int, len(ids))
+	copy(c, ids)
+	return c
+}
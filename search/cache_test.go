@@ -0,0 +1,111 @@
+package search
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// containsSearcher matches if the raw text contains the query and records what it filtered
+type containsSearcher struct {
+	docs     []Document
+	filtered [][]int
+}
+
+func (c *containsSearcher) AddDocument(document Document) {
+	c.docs = append(c.docs, document)
+}
+
+func (c *containsSearcher) FilterEntries(subQueries []SubQuery) []int {
+	results, _ := c.FilterCandidates(context.Background(), idsBetween(0, len(c.docs)), subQueries)
+	return results
+}
+
+func (c *containsSearcher) FilterCandidates(ctx context.Context, candidates []int, subQueries []SubQuery) ([]int, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	c.filtered = append(c.filtered, copyIds(candidates))
+	results := []int{}
+	for _, id := range candidates {
+		matches := true
+		for _, s := range subQueries {
+			matches = matches && strings.Contains(c.docs[id].LoweredParsed[s.Field], s.Query)
+		}
+		if matches {
+			results = append(results, id)
+		}
+	}
+	return results, nil
+}
+
+func (c *containsSearcher) Narrows(previous []SubQuery, next []SubQuery) bool {
+	return Narrows(previous, next)
+}
+
+func (c *containsSearcher) GetDocById(docId int) Document {
+	return c.docs[docId]
+}
+
+func (c *containsSearcher) Count() int {
+	return len(c.docs)
+}
+
+func (c *containsSearcher) MatchPositions(document Document, subQueries []SubQuery) MatchPositions {
+	return MatchPositions{}
+}
+
+func TestCacheRefinesPreviousResults(t *testing.T) {
+	inner := &containsSearcher{}
+	cached := NewCachedSearcher(inner)
+	for _, l := range []string{"hello", "help", "world", "hello world"} {
+		cached.AddDocument(ParseLine(PlainTextParser(), l))
+	}
+	if got := cached.FilterEntries(ParseQuery("hel")); !reflect.DeepEqual([]int{0, 1, 3}, got) {
+		t.Errorf("Expected: '%v' but got '%v'", []int{0, 1, 3}, got)
+	}
+	// typing one more char only filters the previous results
+	if got := cached.FilterEntries(ParseQuery("hell")); !reflect.DeepEqual([]int{0, 3}, got) {
+		t.Errorf("Expected: '%v' but got '%v'", []int{0, 3}, got)
+	}
+	if last := inner.filtered[len(inner.filtered)-1]; !reflect.DeepEqual([]int{0, 1, 3}, last) {
+		t.Errorf("Expected to filter only '%v' but filtered '%v'", []int{0, 1, 3}, last)
+	}
+	// a new document only needs filtering the new one
+	cached.AddDocument(ParseLine(PlainTextParser(), "hellish"))
+	if got := cached.FilterEntries(ParseQuery("hell")); !reflect.DeepEqual([]int{0, 3, 4}, got) {
+		t.Errorf("Expected: '%v' but got '%v'", []int{0, 3, 4}, got)
+	}
+	if last := inner.filtered[len(inner.filtered)-1]; !reflect.DeepEqual([]int{4}, last) {
+		t.Errorf("Expected to filter only '%v' but filtered '%v'", []int{4}, last)
+	}
+	// going back to a shorter query can't reuse a narrower one
+	calls := len(inner.filtered)
+	if got := cached.FilterEntries(ParseQuery("he")); !reflect.DeepEqual([]int{0, 1, 3, 4}, got) {
+		t.Errorf("Expected: '%v' but got '%v'", []int{0, 1, 3, 4}, got)
+	}
+	if last := inner.filtered[len(inner.filtered)-1]; len(inner.filtered) != calls+1 || len(last) != 5 {
+		t.Errorf("Expected to filter all the documents but filtered '%v'", last)
+	}
+	// negations don't narrow
+	if Narrows(ParseQuery("!a"), ParseQuery("!ab")) || Narrows(ParseQuery("a$"), ParseQuery("ab$")) {
+		t.Errorf("Expected negation and suffix not to narrow")
+	}
+}
+
+func TestCacheSkipsCancelledQueries(t *testing.T) {
+	inner := &containsSearcher{}
+	cached := NewCachedSearcher(inner)
+	for _, l := range []string{"hello", "help", "world"} {
+		cached.AddDocument(ParseLine(PlainTextParser(), l))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cached.FilterEntriesContext(ctx, ParseQuery("hel")); err != context.Canceled {
+		t.Errorf("Expected: '%v' but got '%v'", context.Canceled, err)
+	}
+	if got := cached.FilterEntries(ParseQuery("hel")); !reflect.DeepEqual([]int{0, 1}, got) {
+		t.Errorf("Expected: '%v' but got '%v'", []int{0, 1}, got)
+	}
+}
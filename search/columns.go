@@ -0,0 +1,146 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// ColumnsParser slices each line at the positions where its columns start (see columnBoundaries),
+// the last column takes the rest of the line
+func ColumnsParser(headers []string, starts []int) Parser {
+	parse := func(line string) map[string]interface{} {
+		result := map[string]interface{}{}
+		for i, value := range sliceColumns([]rune(line), starts) {
+			result[headers[i]] = value
+		}
+		return result
+	}
+	return Parser{
+		headers: headers,
+		parse:   parse,
+	}
+}
+
+// columnBoundaries infers the columns of fixed width output (ps, df...) from the header and the rows below it.
+// Columns are separated by the positions that are blank in all the lines, so that headers and values
+// with spaces (Mounted on, Oct 10) are kept together. Blank separated parts without header belong
+// to the previous column and so does a header word without values one space after another (Mounted on).
+// It returns the name and the starting rune of each column.
+func columnBoundaries(header string, rows []string) ([]string, []int) {
+	h := []rune(header)
+	lines := [][]rune{h}
+	for _, row := range rows {
+		lines = append(lines, []rune(row))
+	}
+	width := 0
+	for _, l := range lines {
+		if len(l) > width {
+			width = len(l)
+		}
+	}
+	type segment struct{ start, end int }
+	segments := []segment{}
+	for i := 0; i < width; i++ {
+		if isBlankColumn(lines, i) {
+			continue
+		}
+		if last := len(segments) - 1; last >= 0 && segments[last].end == i {
+			segments[last].end = i + 1
+		} else {
+			segments = append(segments, segment{start: i, end: i + 1})
+		}
+	}
+	headers := []string{}
+	starts := []int{}
+	for k, seg := range segments {
+		name := strings.TrimSpace(runeSlice(h, seg.start, seg.end))
+		if len(headers) > 0 {
+			last := len(headers) - 1
+			headerWord := name != "" && seg.start-segments[k-1].end == 1 && !hasValues(lines[1:], seg.start, seg.end)
+			if name == "" || headerWord {
+				if name != "" {
+					headers[last] = strings.TrimSpace(runeSlice(h, starts[last], seg.end))
+				}
+				continue
+			}
+		} else if name == "" {
+			// values before the first header are part of the first column
+			continue
+		}
+		headers = append(headers, name)
+		starts = append(starts, seg.start)
+	}
+	if len(starts) > 0 {
+		starts[0] = 0
+	}
+	return headers, starts
+}
+
+// isBlankColumn tells if the rune at position i is a space (or past the end) in all the lines
+func isBlankColumn(lines [][]rune, i int) bool {
+	for _, l := range lines {
+		if i < len(l) && !unicode.IsSpace(l[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// hasValues tells if any of the lines has something between start and end
+func hasValues(lines [][]rune, start int, end int) bool {
+	for _, l := range lines {
+		if strings.TrimSpace(runeSlice(l, start, end)) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func runeSlice(runes []rune, start int, end int) string {
+	if start > len(runes) {
+		start = len(runes)
+	}
+	if end > len(runes) {
+		end = len(runes)
+	}
+	return string(runes[start:end])
+}
+
+// sliceColumns cuts the line where the columns start. A value wider than the ones of the first lines
+// would be cut in the middle, in that case the cut is moved to the closest space
+func sliceColumns(line []rune, starts []int) []string {
+	values := make([]string, len(starts))
+	for k := range starts {
+		start := 0
+		if k > 0 {
+			start = adjustCut(line, starts[k])
+		}
+		end := len(line)
+		if k+1 < len(starts) {
+			end = adjustCut(line, starts[k+1])
+		}
+		if start > end {
+			start = end
+		}
+		values[k] = strings.TrimSpace(runeSlice(line, start, end))
+	}
+	return values
+}
+
+func adjustCut(line []rune, cut int) int {
+	if cut >= len(line) {
+		return len(line)
+	}
+	if unicode.IsSpace(line[cut]) || unicode.IsSpace(line[cut-1]) {
+		return cut
+	}
+	for d := 1; cut-d > 0 || cut+d < len(line); d++ {
+		if cut-d > 0 && unicode.IsSpace(line[cut-d]) {
+			return cut - d
+		}
+		if cut+d < len(line) && unicode.IsSpace(line[cut+d]) {
+			return cut + d
+		}
+	}
+	return cut
+}
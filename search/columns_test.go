@@ -0,0 +1,53 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestColumnsParserDf(t *testing.T) {
+	lines := []string{
+		"Filesystem     1K-blocks     Used Available Use% Mounted on",
+		"/dev/sda1       61255492 45068620  13045548  78% /",
+		"tmpfs            8155096        0   8155096   0% /dev/shm",
+		"/dev/sdb1      976284628 12345678 963938950   2% /mnt/My Disk",
+	}
+	parser := FormatNameToParser("columns", lines, ParserOptions{}, nil)
+	expectedHeaders := []string{"Filesystem", "1K-blocks", "Used", "Available", "Use%", "Mounted on"}
+	if !reflect.DeepEqual(expectedHeaders, parser.Headers()) {
+		t.Errorf("Expected: '%v' but got '%v'", expectedHeaders, parser.Headers())
+	}
+	// the mount points of the first lines are shorter than the header
+	short := FormatNameToParser("columns", lines[:2], ParserOptions{}, nil)
+	if !reflect.DeepEqual(expectedHeaders, short.Headers()) {
+		t.Errorf("Expected: '%v' but got '%v'", expectedHeaders, short.Headers())
+	}
+	expected := map[string]string{"Filesystem": "/dev/sdb1", "1K-blocks": "976284628", "Used": "12345678", "Available": "963938950", "Use%": "2%", "Mounted on": "/mnt/My Disk"}
+	doc := ParseLine(parser, lines[3])
+	for field, value := range expected {
+		if doc.ParsedLine[field] != value {
+			t.Errorf("%s: Expected: '%v' but got '%v'", field, value, doc.ParsedLine[field])
+		}
+	}
+}
+
+func TestColumnsParserPs(t *testing.T) {
+	lines := []string{
+		"  PID STARTED  TTY      COMMAND",
+		"    1 Oct 10   ?        /sbin/init splash",
+		"  812 Oct 10   pts/0    -bash",
+	}
+	parser := FormatNameToParser("columns", lines, ParserOptions{}, nil)
+	expectedHeaders := []string{"PID", "STARTED", "TTY", "COMMAND"}
+	if !reflect.DeepEqual(expectedHeaders, parser.Headers()) {
+		t.Errorf("Expected: '%v' but got '%v'", expectedHeaders, parser.Headers())
+	}
+	// the PID is wider than the ones of the first lines
+	doc := ParseLine(parser, "123456 Nov 2    ?        /usr/bin/python3 -m http.server")
+	expected := map[string]string{"PID": "123456", "STARTED": "Nov 2", "TTY": "?", "COMMAND": "/usr/bin/python3 -m http.server"}
+	for field, value := range expected {
+		if doc.ParsedLine[field] != value {
+			t.Errorf("%s: Expected: '%v' but got '%v'", field, value, doc.ParsedLine[field])
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package search
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"strconv"
+	"strings"
+
+	"github.com/txominpelu/fnd/log"
+)
+
+// CsvParser parses each record with a csv reader (RFC 4180): quoted values can contain the delimiter,
+// quotes ("") and new lines, and empty columns are kept. Records need to be split with ScanCsvRecords.
+// The columns without header are named by their position (1, 2...)
+func CsvParser(headers []string, comma rune, logger *log.StandardLogger) Parser {
+	parse := func(line string) map[string]interface{} {
+		record, err := readCsvRecord(line, comma)
+		logger.WarnIfErr(err, "when parsing line as csv")
+		result := map[string]interface{}{}
+		for i, value := range record {
+			if i < len(headers) {
+				result[headers[i]] = value
+			} else {
+				result[strconv.Itoa(i+1)] = value
+			}
+		}
+		return result
+	}
+	return Parser{
+		headers: headers,
+		parse:   parse,
+	}
+}
+
+// csvHeaders returns the columns of the first record: its values if it's a header
+// or otherwise their positions (1, 2...). Empty headers are named by their position too
+func csvHeaders(firstline string, comma rune, hasHeader bool) ([]string, error) {
+	record, err := readCsvRecord(firstline, comma)
+	if err != nil {
+		return nil, err
+	}
+	headers := make([]string, len(record))
+	for i, value := range record {
+		if hasHeader && strings.TrimSpace(value) != "" {
+			headers[i] = strings.TrimSpace(value)
+		} else {
+			headers[i] = strconv.Itoa(i + 1)
+		}
+	}
+	return headers, nil
+}
+
+func readCsvRecord(line string, comma rune) ([]string, error) {
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.Comma = comma
+	// records can have a different number of columns than the header
+	reader.FieldsPerRecord = -1
+	// a quote in the middle of an unquoted value is kept as is
+	reader.LazyQuotes = true
+	return reader.Read()
+}
+
+// ScanCsvRecords is a bufio.SplitFunc that returns a csv record at a time,
+// a new line only ends the record if it's not inside a quoted value
+func ScanCsvRecords(comma rune) bufio.SplitFunc {
+	delimiter := []byte(string(comma))
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		inQuotes := false
+		fieldStart := true
+		for i := 0; i < len(data); i++ {
+			c := data[i]
+			if inQuotes {
+				if c == '"' {
+					if i+1 == len(data) && !atEOF {
+						// an escaped quote ("") could continue in the next read
+						return 0, nil, nil
+					}
+					if i+1 < len(data) && data[i+1] == '"' {
+						i++
+					} else {
+						inQuotes = false
+					}
+				}
+				continue
+			}
+			if c == '\n' {
+				return i + 1, bytes.TrimSuffix(data[:i], []byte{'\r'}), nil
+			}
+			if bytes.HasPrefix(data[i:], delimiter) {
+				i += len(delimiter) - 1
+				fieldStart = true
+				continue
+			}
+			inQuotes = c == '"' && fieldStart
+			fieldStart = false
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), bytes.TrimSuffix(data, []byte{'\r'}), nil
+		}
+		return 0, nil, nil
+	}
+}
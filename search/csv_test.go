@@ -0,0 +1,46 @@
+package search
+
+import (
+	"bufio"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanCsvRecords(t *testing.T) {
+	input := "name,notes\r\n\"Smith, John\",\"line one\nline \"\"two\"\"\"\nJane,5\" screen\n"
+	scanner := bufio.NewScanner(strings.NewReader(input))
+	scanner.Split(FormatSplitFunc("csv"))
+	records := []string{}
+	for scanner.Scan() {
+		records = append(records, scanner.Text())
+	}
+	expected := []string{"name,notes", "\"Smith, John\",\"line one\nline \"\"two\"\"\"", "Jane,5\" screen"}
+	if !reflect.DeepEqual(expected, records) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, records)
+	}
+}
+
+func TestCsvParser(t *testing.T) {
+	parser := FormatNameToParser("csv", []string{"name,,city"}, ParserOptions{CsvHeader: true}, nil)
+	if !reflect.DeepEqual([]string{"name", "2", "city"}, parser.Headers()) {
+		t.Errorf("Expected: '%v' but got '%v'", []string{"name", "2", "city"}, parser.Headers())
+	}
+	if !parser.HasHeaderLine() {
+		t.Errorf("Expected the first line to be the header")
+	}
+	doc := ParseLine(parser, "\"Smith, John\",,\"New\nYork\",extra")
+	expected := map[string]string{"name": "Smith, John", "2": "", "city": "New\nYork", "4": "extra"}
+	for field, value := range expected {
+		if got, ok := doc.ParsedLine[field]; !ok || got != value {
+			t.Errorf("%s: Expected: '%v' but got '%v'", field, value, got)
+		}
+	}
+	tsv := FormatNameToParser("tsv", []string{"a\tb c"}, ParserOptions{}, nil)
+	if !reflect.DeepEqual([]string{"1", "2"}, tsv.Headers()) || tsv.HasHeaderLine() {
+		t.Errorf("Expected: '%v' but got '%v'", []string{"1", "2"}, tsv.Headers())
+	}
+	if got := ParseLine(tsv, "a\tb c").ParsedLine["2"]; got != "b c" {
+		t.Errorf("Expected: '%v' but got '%v'", "b c", got)
+	}
+}
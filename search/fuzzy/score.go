@@ -0,0 +1,193 @@
+package fuzzy
+
+import (
+	"unicode"
+
+	"github.com/txominpelu/fnd/search"
+)
+
+// Scoring constants, same values used by fzf's v1 algorithm.
+// A match is worth scoreMatch, every gap between matched characters
+// is penalized and characters matched at the beginning of a word get a bonus.
+const (
+	scoreMatch        = 16
+	scoreGapStart     = -3
+	scoreGapExtension = -1
+
+	// e.g "fb" on "foo-bar" should win over "fb" on "foobar"
+	bonusBoundary = scoreMatch / 2
+	bonusNonWord  = scoreMatch / 2
+	// e.g "fb" on "fooBar" or "f1" on "foo1"
+	bonusCamel123 = bonusBoundary + scoreGapExtension
+	// consecutive chars get at least the bonus that would cancel a gap
+	bonusConsecutive = -(scoreGapStart + scoreGapExtension)
+	// the first char of the pattern counts double
+	bonusFirstCharMultiplier = 2
+)
+
+type charClass int
+
+const (
+	charNonWord charClass = iota
+	charLower
+	charUpper
+	charLetter
+	charNumber
+)
+
+func classOf(r rune) charClass {
+	switch {
+	case unicode.IsLower(r):
+		return charLower
+	case unicode.IsUpper(r):
+		return charUpper
+	case unicode.IsLetter(r):
+		return charLetter
+	case unicode.IsNumber(r):
+		return charNumber
+	default:
+		return charNonWord
+	}
+}
+
+func bonusFor(prevClass charClass, class charClass) int {
+	if prevClass == charNonWord && class != charNonWord {
+		return bonusBoundary
+	} else if prevClass == charLower && class == charUpper ||
+		prevClass != charNumber && class == charNumber {
+		return bonusCamel123
+	} else if class == charNonWord {
+		return bonusNonWord
+	}
+	return 0
+}
+
+// fuzzyScore finds the shortest window of text where pattern appears as a subsequence
+// and scores it. Text and pattern are compared without accents unless keepAccents (see search.FoldText)
+// and ignoring case unless caseSensitive.
+// It also returns the positions of the matched runes, or false if the pattern doesn't match.
+func fuzzyScore(text string, pattern string, caseSensitive bool, keepAccents bool) (int, []int, bool) {
+	p, t, origin, fold := foldForMatch(text, pattern, caseSensitive, keepAccents)
+	if len(p) == 0 {
+		return 0, []int{}, true
+	}
+	// forward scan: find where the first complete match ends
+	pidx := 0
+	start := -1
+	end := -1
+	for idx, r := range t {
+		if fold(r) == p[pidx] {
+			if start < 0 {
+				start = idx
+			}
+			pidx++
+			if pidx == len(p) {
+				end = idx + 1
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return 0, nil, false
+	}
+	// backward scan: find the latest start for that end to get the shortest window
+	pidx = len(p) - 1
+	for idx := end - 1; idx >= start; idx-- {
+		if fold(t[idx]) == p[pidx] {
+			pidx--
+			if pidx < 0 {
+				start = idx
+				break
+			}
+		}
+	}
+	score, positions := scoreWindow(t, p, start, end, fold)
+	return score, originalPositions(origin, positions), true
+}
+
+// foldForMatch prepares text and pattern to be compared rune by rune with fold applied to the runes of text.
+// origin maps the runes of the returned text to the ones of the original text
+func foldForMatch(text string, pattern string, caseSensitive bool, keepAccents bool) ([]rune, []rune, []int, func(rune) rune) {
+	t, origin := search.FoldText(text, keepAccents)
+	if caseSensitive {
+		return []rune(search.Fold(pattern, keepAccents)), t, origin, foldFor(caseSensitive)
+	}
+	return []rune(search.Lower(pattern, keepAccents)), t, origin, foldFor(caseSensitive)
+}
+
+// foldFor returns how a rune of text is changed before comparing it with a rune of the pattern
+func foldFor(caseSensitive bool) func(rune) rune {
+	if caseSensitive {
+		return func(r rune) rune { return r }
+	}
+	return unicode.ToLower
+}
+
+// originalPositions converts positions of the folded runes to the ones of the original text,
+// a rune that was decomposed in several ones is only returned once
+func originalPositions(origin []int, positions []int) []int {
+	result := make([]int, 0, len(positions))
+	for _, p := range positions {
+		if len(result) == 0 || result[len(result)-1] != origin[p] {
+			result = append(result, origin[p])
+		}
+	}
+	return result
+}
+
+func scoreWindow(text []rune, pattern []rune, start int, end int, fold func(rune) rune) (int, []int) {
+	score := 0
+	positions := make([]int, 0, len(pattern))
+	inGap := false
+	consecutive := 0
+	firstBonus := 0
+	prevClass := charNonWord
+	if start > 0 {
+		prevClass = classOf(text[start-1])
+	}
+	pidx := 0
+	for idx := start; idx < end; idx++ {
+		r := text[idx]
+		class := classOf(r)
+		if pidx < len(pattern) && fold(r) == pattern[pidx] {
+			score += scoreMatch
+			bonus := bonusFor(prevClass, class)
+			if consecutive == 0 {
+				firstBonus = bonus
+			} else {
+				// a boundary in the middle of a chunk starts a new chunk
+				if bonus == bonusBoundary {
+					firstBonus = bonus
+				}
+				bonus = maxInt(bonus, maxInt(firstBonus, bonusConsecutive))
+			}
+			if pidx == 0 {
+				score += bonus * bonusFirstCharMultiplier
+			} else {
+				score += bonus
+			}
+			inGap = false
+			consecutive++
+			pidx++
+			positions = append(positions, idx)
+		} else {
+			if inGap {
+				score += scoreGapExtension
+			} else {
+				score += scoreGapStart
+			}
+			inGap = true
+			consecutive = 0
+			firstBonus = 0
+		}
+		prevClass = class
+	}
+	return score, positions
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -1,86 +1,251 @@
 package fuzzy
 
 import (
+	"context"
+	"math"
+	"runtime"
+	"sync"
+	"unicode/utf8"
+
 	"github.com/txominpelu/fnd/search"
 )
 
+// FuzzySearcher is safe for one goroutine adding documents while others query it.
+// Documents are only appended so queries work on a snapshot of them without holding the lock.
+// Queries split the documents in chunks that are filtered in parallel.
 type FuzzySearcher struct {
-	docs   []search.Document
-	docIds []int
+	mutex   sync.RWMutex
+	docs    []search.Document
+	workers int
 }
 
+// minChunkSize avoids paying for goroutines when there are few documents to filter
+const minChunkSize = 4096
+
+// cancelCheckInterval is the number of documents filtered between checks of the context
+const cancelCheckInterval = 1024
+
+// NewFuzzySearcher uses as many workers as CPUs to filter
 func NewFuzzySearcher() *FuzzySearcher {
+	return NewFuzzySearcherWithWorkers(runtime.NumCPU())
+}
+
+func NewFuzzySearcherWithWorkers(workers int) *FuzzySearcher {
+	if workers < 1 {
+		workers = 1
+	}
 	return &FuzzySearcher{
-		docs:   []search.Document{},
-		docIds: []int{},
+		docs:    []search.Document{},
+		workers: workers,
 	}
 }
 
 func (f *FuzzySearcher) AddDocument(d search.Document) {
-	f.docIds = append(f.docIds, len(f.docs))
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
 	f.docs = append(f.docs, d)
 }
 
+// snapshot returns the documents added so far, docId = index in the slice
+func (f *FuzzySearcher) snapshot() []search.Document {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.docs
+}
+
+// FilterEntries returns the matching docIds in increasing order
 func (f *FuzzySearcher) FilterEntries(subQueries []search.SubQuery) []int {
-	if len(subQueries) > 0 {
-		results := make([]int, len(f.docIds))
-		for i, dId := range f.docIds {
-			if len(results) > i {
-				results[i] = dId
-			}
+	results, _ := f.FilterEntriesContext(context.Background(), subQueries)
+	return results
+}
+
+// FilterEntriesContext is FilterEntries but it stops with ctx's error as soon as ctx is done
+func (f *FuzzySearcher) FilterEntriesContext(ctx context.Context, subQueries []search.SubQuery) ([]int, error) {
+	docs := f.snapshot()
+	docIds := make([]int, len(docs))
+	for i := range docs {
+		docIds[i] = i
+	}
+	return f.filterParallel(ctx, docs, docIds, subQueries)
+}
+
+// FilterCandidates only checks the given docIds, it keeps their order
+func (f *FuzzySearcher) FilterCandidates(ctx context.Context, candidates []int, subQueries []search.SubQuery) ([]int, error) {
+	return f.filterParallel(ctx, f.snapshot(), candidates, subQueries)
+}
+
+// Narrows is true when the query was only extended as in fzf (e.g by typing more characters)
+func (f *FuzzySearcher) Narrows(previous []search.SubQuery, next []search.SubQuery) bool {
+	return search.Narrows(previous, next)
+}
+
+// filterParallel splits docIds in consecutive chunks, one per worker
+func (f *FuzzySearcher) filterParallel(ctx context.Context, docs []search.Document, docIds []int, subQueries []search.SubQuery) ([]int, error) {
+	chunks := len(docIds) / minChunkSize
+	if chunks > f.workers {
+		chunks = f.workers
+	}
+	if chunks <= 1 {
+		return filter(ctx, docs, docIds, subQueries)
+	}
+	chunkSize := (len(docIds) + chunks - 1) / chunks
+	results := make([][]int, chunks)
+	errs := make([]error, chunks)
+	var wg sync.WaitGroup
+	for c := 0; c < chunks; c++ {
+		start := c * chunkSize
+		end := start + chunkSize
+		if end > len(docIds) {
+			end = len(docIds)
 		}
-		for _, subQ := range subQueries {
-			results = f.filter(results, subQ)
+		wg.Add(1)
+		go func(c int, chunk []int) {
+			defer wg.Done()
+			results[c], errs[c] = filter(ctx, docs, chunk, subQueries)
+		}(c, docIds[start:end])
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
 		}
-		return results
 	}
-	// otherwise if no query all docs match
-	return f.docIds
-
+	// merging the chunks in order keeps the order of docIds
+	total := 0
+	for _, r := range results {
+		total += len(r)
+	}
+	merged := make([]int, 0, total)
+	for _, r := range results {
+		merged = append(merged, r...)
+	}
+	return merged, nil
 }
 
 func (f *FuzzySearcher) Count() int {
-	return len(f.docs)
+	return len(f.snapshot())
 }
 
 func (f *FuzzySearcher) GetDocById(docId int) search.Document {
-	return f.docs[docId]
+	return f.snapshot()[docId]
 }
 
-func (f *FuzzySearcher) filter(docIds []int, subQuery search.SubQuery) []int {
+// Score sums the score of the document for each subquery, taking the best alternative.
+// Higher is better, documents that don't match get the lowest possible score.
+func (f *FuzzySearcher) Score(docId int, subQueries []search.SubQuery) float64 {
+	doc := f.GetDocById(docId)
+	total := 0
+	for _, subQ := range subQueries {
+		best := 0
+		found := false
+		for _, alt := range subQ.Alternatives() {
+			score, _, ok := matchOne(doc, alt)
+			if ok && (!found || score > best) {
+				best = score
+				found = true
+			}
+		}
+		if !found {
+			return math.Inf(-1)
+		}
+		total += best
+	}
+	return float64(total)
+}
+
+// MatchPositions returns the runes that were picked by the match of each subquery
+func (f *FuzzySearcher) MatchPositions(doc search.Document, subQueries []search.SubQuery) search.MatchPositions {
+	positions := search.MatchPositions{}
+	for _, subQ := range subQueries {
+		for _, alt := range subQ.Alternatives() {
+			if _, matched, ok := matchOne(doc, alt); ok {
+				positions[alt.Field] = append(positions[alt.Field], search.PositionsToRanges(matched)...)
+			}
+		}
+	}
+	return positions
+}
+
+// filter returns the docIds that match all the subqueries
+// if there are no subqueries all docs match
+func filter(ctx context.Context, docs []search.Document, docIds []int, subQueries []search.SubQuery) ([]int, error) {
 	result := []int{}
-	for _, docId := range docIds {
-		v := f.GetDocById(docId).LoweredParsed[subQuery.Field]
-		if matchesFuzzy(v, subQuery.Query) {
+	for i, docId := range docIds {
+		if i%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		matchesAll := true
+		for _, subQ := range subQueries {
+			if !matches(docs[docId], subQ) {
+				matchesAll = false
+				break
+			}
+		}
+		if matchesAll {
 			result = append(result, docId)
 		}
 	}
-	return result
+	return result, nil
+}
+
+// matches tells if any of the alternatives of the subquery matches the document
+func matches(doc search.Document, subQuery search.SubQuery) bool {
+	for _, alt := range subQuery.Alternatives() {
+		var ok bool
+		if alt.Kind == search.Fuzzy {
+			ok = matchesFuzzy(search.FieldText(doc, alt), alt.Query) != alt.Negated
+		} else {
+			ok = search.MatchesAlternative(doc, alt)
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOne scores a single alternative of a subquery against its field and returns the matched runes.
+// Negated and Where subqueries don't contribute to the score nor to the matched runes.
+func matchOne(doc search.Document, subQuery search.SubQuery) (int, []int, bool) {
+	if subQuery.Negated {
+		return 0, []int{}, search.MatchesAlternative(doc, subQuery)
+	}
+	if subQuery.Kind == search.Where {
+		return 0, []int{}, subQuery.Predicate(doc)
+	}
+	text := doc.ParsedLine[subQuery.Field]
+	if subQuery.Kind == search.Fuzzy {
+		return fuzzyScore(text, subQuery.Query, subQuery.CaseSensitive, subQuery.KeepAccents)
+	}
+	ranges := search.FindMatches(text, subQuery)
+	if len(ranges) == 0 {
+		return 0, nil, false
+	}
+	first := ranges[0]
+	score, _ := scoreWindow([]rune(text), []rune(subQuery.Query), first.Start, first.End, foldFor(subQuery.CaseSensitive))
+	positions := []int{}
+	for _, r := range ranges {
+		for p := r.Start; p < r.End; p++ {
+			positions = append(positions, p)
+		}
+	}
+	return score, positions, true
 }
 
+// matchesFuzzy tells if all the runes of fuzzy appear in text in the same order
 func matchesFuzzy(text string, fuzzy string) bool {
-	i := len(fuzzy)
-	if i > 0 {
-		fuzzyChan := func() chan rune {
-			out := make(chan rune, len(fuzzy))
-			for _, r := range fuzzy {
-				out <- r
-			}
-			return out
-		}()
-		charToSearch := <-fuzzyChan
-		for _, ch := range text {
-			if ch == charToSearch {
-				i--
-				if i > 0 {
-					charToSearch = <-fuzzyChan
-				} else {
-					break
-				}
+	if fuzzy == "" {
+		return true
+	}
+	charToSearch, size := utf8.DecodeRuneInString(fuzzy)
+	for _, ch := range text {
+		if ch == charToSearch {
+			fuzzy = fuzzy[size:]
+			if fuzzy == "" {
+				return true
 			}
+			charToSearch, size = utf8.DecodeRuneInString(fuzzy)
 		}
-		return i == 0
-	} else {
-		return true
 	}
+	return false
 }
@@ -1,7 +1,10 @@
 package fuzzy
 
 import (
+	"context"
+	"fmt"
 	"reflect"
+	"runtime"
 	"testing"
 
 	"github.com/txominpelu/fnd/search"
@@ -24,6 +27,7 @@ func TestFuzzy(t *testing.T) {
 	gotDocs := search.SortDocuments(
 		fuzzySearcher.FilterEntries(search.ParseQuery("bc")),
 		fuzzySearcher,
+		func(d1 int, d2 int) bool { return d1 < d2 },
 	)
 	got := make([]string, len(gotDocs))
 	for i, d := range gotDocs {
@@ -51,6 +55,7 @@ func TestUpperCase(t *testing.T) {
 	gotDocs := search.SortDocuments(
 		fuzzySearcher.FilterEntries(search.ParseQuery("bc")),
 		fuzzySearcher,
+		func(d1 int, d2 int) bool { return d1 < d2 },
 	)
 	got := make([]string, len(gotDocs))
 	for i, d := range gotDocs {
@@ -60,3 +65,287 @@ func TestUpperCase(t *testing.T) {
 		t.Errorf("Expected: '%v' but got '%v'", expected, got)
 	}
 }
+
+func TestScore(t *testing.T) {
+	fuzzySearcher := NewFuzzySearcher()
+	lines := []string{
+		"xfxxxxbxx",
+		"foobar",
+		"foo-bar",
+		"fooBar",
+		"nothing",
+	}
+	for _, l := range lines {
+		fuzzySearcher.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	subQueries := search.ParseQuery("fb")
+	expected := []string{
+		"foo-bar",
+		"fooBar",
+		"foobar",
+		"xfxxxxbxx",
+	}
+	gotDocs := search.SortDocuments(
+		fuzzySearcher.FilterEntries(subQueries),
+		fuzzySearcher,
+		func(d1 int, d2 int) bool {
+			return fuzzySearcher.Score(d1, subQueries) > fuzzySearcher.Score(d2, subQueries)
+		},
+	)
+	got := make([]string, len(gotDocs))
+	for i, d := range gotDocs {
+		got[i] = d.RawText
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, got)
+	}
+}
+
+func TestMatchPositions(t *testing.T) {
+	fuzzySearcher := NewFuzzySearcher()
+	doc := search.ParseLine(search.PlainTextParser(), "foo-bar")
+	fuzzySearcher.AddDocument(doc)
+	expected := search.MatchPositions{
+		"$": []search.Range{{Start: 0, End: 1}, {Start: 4, End: 6}},
+	}
+	got := fuzzySearcher.MatchPositions(doc, search.ParseQuery("fba"))
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, got)
+	}
+}
+
+func TestExtendedQuery(t *testing.T) {
+	fuzzySearcher := NewFuzzySearcher()
+	lines := []string{
+		"main.go",
+		"main_test.go",
+		"README.md",
+		"cmd/root.go",
+	}
+	for _, l := range lines {
+		fuzzySearcher.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	cases := map[string][]string{
+		"go$ !test":   {"main.go", "cmd/root.go"},
+		"^main":       {"main.go", "main_test.go"},
+		"'root | .md": {"README.md", "cmd/root.go"},
+		"mngo":        {"main.go", "main_test.go"},
+		"'mngo":       {},
+	}
+	for query, expected := range cases {
+		gotDocs := search.SortDocuments(
+			fuzzySearcher.FilterEntries(search.ParseQuery(query)),
+			fuzzySearcher,
+			func(d1 int, d2 int) bool { return d1 < d2 },
+		)
+		got := make([]string, len(gotDocs))
+		for i, d := range gotDocs {
+			got[i] = d.RawText
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Query '%s' expected: '%v' but got '%v'", query, expected, got)
+		}
+	}
+}
+
+func TestNumericQuery(t *testing.T) {
+	fuzzySearcher := NewFuzzySearcher()
+	parser := search.TabularParser([]string{"USER", "PID", "%CPU", "COMMAND"}, ' ')
+	lines := []string{
+		"root 1 0.0 /sbin/init",
+		"me 1234 12.5 firefox",
+		"me 99 n/a defunct",
+	}
+	for _, l := range lines {
+		fuzzySearcher.AddDocument(search.ParseLine(parser, l))
+	}
+	expected := []string{"me 1234 12.5 firefox"}
+	gotDocs := search.SortDocuments(
+		fuzzySearcher.FilterEntries(search.ParseQuery("%CPU:>=0.5 USER:me")),
+		fuzzySearcher,
+		func(d1 int, d2 int) bool { return d1 < d2 },
+	)
+	got := make([]string, len(gotDocs))
+	for i, d := range gotDocs {
+		got[i] = d.RawText
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, got)
+	}
+}
+
+func TestConcurrentAddAndQuery(t *testing.T) {
+	fuzzySearcher := NewFuzzySearcher()
+	total := 2000
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < total; i++ {
+			fuzzySearcher.AddDocument(search.ParseLine(search.PlainTextParser(), fmt.Sprintf("line %d", i)))
+		}
+		close(done)
+	}()
+	subQueries := search.ParseQuery("line 1")
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		docIds := fuzzySearcher.FilterEntries(subQueries)
+		search.SortDocuments(docIds, fuzzySearcher, func(d1 int, d2 int) bool {
+			return fuzzySearcher.Score(d1, subQueries) > fuzzySearcher.Score(d2, subQueries)
+		})
+		if len(docIds) > fuzzySearcher.Count() {
+			t.Fatalf("Got %d results with only %d documents", len(docIds), fuzzySearcher.Count())
+		}
+	}
+	if got := len(fuzzySearcher.FilterEntries([]search.SubQuery{})); got != total {
+		t.Errorf("Expected: '%d' documents but got '%d'", total, got)
+	}
+}
+
+// similar to the output of `find /`
+func benchmarkLines(n int) []string {
+	dirs := []string{"usr", "lib", "share", "local", "bin", "etc", "var", "log", "python3", "site-packages"}
+	lines := make([]string, n)
+	for i := 0; i < n; i++ {
+		lines[i] = fmt.Sprintf("/%s/%s/%s/file_%d.txt", dirs[i%len(dirs)], dirs[(i/7)%len(dirs)], dirs[(i/13)%len(dirs)], i)
+	}
+	return lines
+}
+
+func benchmarkFilter(b *testing.B, workers int) {
+	fuzzySearcher := NewFuzzySearcherWithWorkers(workers)
+	for _, l := range benchmarkLines(1000000) {
+		fuzzySearcher.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	subQueries := search.ParseQuery("usrpy fl9")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fuzzySearcher.FilterEntries(subQueries)
+	}
+}
+
+func BenchmarkFilterSequential(b *testing.B) {
+	benchmarkFilter(b, 1)
+}
+
+func BenchmarkFilterParallel(b *testing.B) {
+	benchmarkFilter(b, runtime.NumCPU())
+}
+
+func TestParallelFilterKeepsOrder(t *testing.T) {
+	lines := benchmarkLines(5 * minChunkSize)
+	sequential := NewFuzzySearcherWithWorkers(1)
+	parallel := NewFuzzySearcherWithWorkers(4)
+	for _, l := range lines {
+		sequential.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+		parallel.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	subQueries := search.ParseQuery("usrpy fl9")
+	expected := sequential.FilterEntries(subQueries)
+	got := parallel.FilterEntries(subQueries)
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected %d results but got %d", len(expected), len(got))
+	}
+}
+
+func TestCancelledFilter(t *testing.T) {
+	searcher := NewFuzzySearcherWithWorkers(4)
+	for _, l := range benchmarkLines(5 * minChunkSize) {
+		searcher.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := searcher.FilterEntriesContext(ctx, search.ParseQuery("usrpy")); err != context.Canceled {
+		t.Errorf("Expected: '%v' but got '%v'", context.Canceled, err)
+	}
+}
+
+func TestIgnoresAccents(t *testing.T) {
+	searcher := NewFuzzySearcher()
+	for _, l := range []string{"Crème brûlée", "creamy", "ｆｎｄ rocks"} {
+		searcher.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	cases := map[string][]int{
+		"creme":  {0},
+		"brulee": {0},
+		"fnd":    {2},
+		"crème":  {0},
+	}
+	for query, expected := range cases {
+		if got := searcher.FilterEntries(search.ParseQuery(query)); !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", query, expected, got)
+		}
+	}
+	positions := searcher.MatchPositions(searcher.GetDocById(0), search.ParseQuery("creme"))
+	expected := search.MatchPositions{"$": []search.Range{{Start: 0, End: 5}}}
+	if !reflect.DeepEqual(expected, positions) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, positions)
+	}
+}
+
+func TestKeepAccents(t *testing.T) {
+	searcher := NewFuzzySearcher()
+	for _, l := range []string{"Crème brûlée", "creamy creme"} {
+		searcher.AddDocument(search.ParseLine(search.PlainTextParser().KeepAccents(), l))
+	}
+	queryParser := search.QueryParser{KeepAccents: true}
+	cases := map[string]search.MatchPositions{
+		"creme":  {},
+		"crème":  {"$": []search.Range{{Start: 0, End: 5}}},
+		"'brûlé": {"$": []search.Range{{Start: 6, End: 11}}},
+		"'brule": {},
+	}
+	for query, expected := range cases {
+		subQueries, _ := queryParser.Parse(query)
+		positions := searcher.MatchPositions(searcher.GetDocById(0), subQueries)
+		if !reflect.DeepEqual(expected, positions) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", query, expected, positions)
+		}
+	}
+}
+
+func TestSmartCase(t *testing.T) {
+	searcher := NewFuzzySearcher()
+	for _, l := range []string{"README.md", "readme.txt", "ReadMe.go"} {
+		searcher.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	cases := map[string][]int{
+		"readme": {0, 1, 2},
+		"RM":     {0, 2},
+		"README": {0},
+		"RdMe":   {2},
+	}
+	for query, expected := range cases {
+		subQueries, _ := search.QueryParser{Case: search.CaseSmart}.Parse(query)
+		if got := searcher.FilterEntries(subQueries); !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", query, expected, got)
+		}
+	}
+	subQueries, _ := search.QueryParser{Case: search.CaseSmart}.Parse("RM")
+	positions := searcher.MatchPositions(searcher.GetDocById(2), subQueries)
+	expected := search.MatchPositions{"$": []search.Range{{Start: 0, End: 1}, {Start: 4, End: 5}}}
+	if !reflect.DeepEqual(expected, positions) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, positions)
+	}
+}
+
+func TestSmartCaseIgnoresAccents(t *testing.T) {
+	searcher := NewFuzzySearcher()
+	for _, l := range []string{"Cafe de Flore", "café noir", "Café crème"} {
+		searcher.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	for _, query := range []string{"Cafe", "Café", "'Cafe"} {
+		subQueries, _ := search.QueryParser{Case: search.CaseSmart}.Parse(query)
+		expected := []int{0, 2}
+		if got := searcher.FilterEntries(subQueries); !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", query, expected, got)
+		}
+		positions := searcher.MatchPositions(searcher.GetDocById(2), subQueries)
+		expectedPositions := search.MatchPositions{"$": []search.Range{{Start: 0, End: 4}}}
+		if !reflect.DeepEqual(expectedPositions, positions) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", query, expectedPositions, positions)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package search
+
+import (
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Range of runes [Start, End) of a field that matched a query
+type Range struct {
+	Start int
+	End   int
+}
+
+// MatchPositions maps each field to the ranges of it that matched
+type MatchPositions = map[string][]Range
+
+// PositionsToRanges merges sorted rune positions into ranges of consecutive runes
+func PositionsToRanges(positions []int) []Range {
+	ranges := []Range{}
+	for _, p := range positions {
+		if len(ranges) > 0 && ranges[len(ranges)-1].End == p {
+			ranges[len(ranges)-1].End = p + 1
+		} else {
+			ranges = append(ranges, Range{Start: p, End: p + 1})
+		}
+	}
+	return ranges
+}
+
+// MergeRanges sorts the ranges and merges the ones that overlap or touch,
+// e.g. a word found as itself and as the start of a longer token: [0, 3) [0, 7) -> [0, 7)
+func MergeRanges(ranges []Range) []Range {
+	sorted := append([]Range{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	merged := []Range{}
+	for _, r := range sorted {
+		if last := len(merged) - 1; last >= 0 && r.Start <= merged[last].End {
+			if r.End > merged[last].End {
+				merged[last].End = r.End
+			}
+		} else {
+			merged = append(merged, r)
+		}
+	}
+	return merged
+}
+
+// FindAll returns the non overlapping ranges where query appears in text ignoring case and,
+// unless keepAccents, accents
+func FindAll(text string, query string, keepAccents bool) []Range {
+	t, origin := FoldText(text, keepAccents)
+	q := []rune(Lower(query, keepAccents))
+	ranges := []Range{}
+	if len(q) == 0 {
+		return ranges
+	}
+	for i := 0; i+len(q) <= len(t); i++ {
+		matches := true
+		for j, r := range q {
+			if unicode.ToLower(t[i+j]) != r {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			ranges = append(ranges, OriginalRange(origin, i, i+len(q)))
+			i += len(q) - 1
+		}
+	}
+	return ranges
+}
+
+// FindExact returns the non overlapping ranges where query appears in text with the same case,
+// accents are ignored unless keepAccents
+func FindExact(text string, query string, keepAccents bool) []Range {
+	t, origin := FoldText(text, keepAccents)
+	q := Fold(query, keepAccents)
+	length := utf8.RuneCountInString(q)
+	ranges := []Range{}
+	if length == 0 {
+		return ranges
+	}
+	for i := 0; i+length <= len(t); i++ {
+		if string(t[i:i+length]) == q {
+			ranges = append(ranges, OriginalRange(origin, i, i+length))
+			i += length - 1
+		}
+	}
+	return ranges
+}
+
+// FindMatches returns the ranges of text that matched a non fuzzy subquery
+func FindMatches(text string, subQuery SubQuery) []Range {
+	folded, origin := FoldText(text, subQuery.KeepAccents)
+	length := utf8.RuneCountInString(text)
+	find := FindAll
+	compared := Lower(text, subQuery.KeepAccents)
+	query := Lower(subQuery.Query, subQuery.KeepAccents)
+	if subQuery.CaseSensitive {
+		find = FindExact
+		compared = string(folded)
+		query = Fold(subQuery.Query, subQuery.KeepAccents)
+	}
+	subQuery.Query = query
+	queryLength := utf8.RuneCountInString(subQuery.Query)
+	if !MatchesText(compared, subQuery) {
+		return []Range{}
+	}
+	switch subQuery.Kind {
+	case Exact:
+		return find(text, subQuery.Query, subQuery.KeepAccents)
+	case Prefix:
+		if queryLength > 0 {
+			return []Range{OriginalRange(origin, 0, queryLength)}
+		}
+	case Suffix:
+		if queryLength > 0 {
+			return []Range{OriginalRange(origin, len(folded)-queryLength, len(folded))}
+		}
+	case Equal, Greater, GreaterOrEqual, Less, LessOrEqual, Between:
+		return []Range{{Start: 0, End: length}}
+	}
+	return []Range{}
+}
+
+// OriginalRange converts the range [start, end) of the folded runes (see FoldAccents) to the runes of the text
+func OriginalRange(origin []int, start int, end int) Range {
+	return Range{Start: origin[start], End: origin[end-1] + 1}
+}
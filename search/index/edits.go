@@ -0,0 +1,123 @@
+package index
+
+// Typo tolerance: a query word matches the words of the vocabulary that are
+// a few edits away (insertions, deletions, substitutions and transpositions of
+// two adjacent runes). The vocabulary of a field is kept in a trie so the edit
+// distance rows are only computed once for the words sharing a prefix
+// and branches that are already too far from the query are skipped.
+
+// allowedEdits limits the edits for short words, otherwise they'd match almost anything
+// 1-2 runes: none, 3-5 runes: 1, longer: 2. Never more than maxEdits
+func allowedEdits(query []rune, maxEdits int) int {
+	allowed := 0
+	if len(query) > 5 {
+		allowed = 2
+	} else if len(query) > 2 {
+		allowed = 1
+	}
+	if allowed > maxEdits {
+		return maxEdits
+	}
+	return allowed
+}
+
+// nextRow computes the edit distances between every prefix of query and a word
+// given the rows of the word without its last rune (row) and without its last two runes (prevRow, nil if none)
+// prevChar is the rune before char in the word
+func nextRow(query []rune, prevRow []int, row []int, prevChar rune, char rune) []int {
+	current := make([]int, len(query)+1)
+	current[0] = row[0] + 1
+	for j := 1; j <= len(query); j++ {
+		cost := 1
+		if query[j-1] == char {
+			cost = 0
+		}
+		current[j] = minInt(minInt(current[j-1]+1, row[j]+1), row[j-1]+cost)
+		if prevRow != nil && j > 1 && query[j-1] == prevChar && query[j-2] == char {
+			current[j] = minInt(current[j], prevRow[j-2]+1)
+		}
+	}
+	return current
+}
+
+func firstRow(query []rune) []int {
+	row := make([]int, len(query)+1)
+	for j := range row {
+		row[j] = j
+	}
+	return row
+}
+
+// editDistance returns the edits needed to turn word into query.
+// If prefix is true it's the edits to turn any prefix of word into query
+func editDistance(query []rune, word []rune, prefix bool) int {
+	var prevRow []int
+	row := firstRow(query)
+	best := row[len(query)]
+	for i, char := range word {
+		prevChar := rune(0)
+		if i > 0 {
+			prevChar = word[i-1]
+		}
+		prevRow, row = row, nextRow(query, prevRow, row, prevChar, char)
+		if prefix {
+			best = minInt(best, row[len(query)])
+		}
+	}
+	if !prefix {
+		return row[len(query)]
+	}
+	return best
+}
+
+// withinEdits returns the words of the trie at most maxEdits away from query with their edit distance.
+// If prefix is true the words starting with something at most maxEdits away match too
+func (t *trie) withinEdits(query string, maxEdits int, prefix bool) map[string]int {
+	results := map[string]int{}
+	q := []rune(query)
+	t.root.searchEdits(q, []rune{}, nil, firstRow(q), maxEdits+1, maxEdits, prefix, results)
+	return results
+}
+
+// searchEdits visits the node reached with path, row is the one of path without its last rune.
+// prefixEdits is the smallest distance of a prefix of path to the query (only used if prefix is true)
+func (n *trieNode) searchEdits(q []rune, path []rune, prevRow []int, row []int, prefixEdits int, maxEdits int, prefix bool, results map[string]int) {
+	current := row
+	if len(path) > 0 {
+		prevChar := rune(0)
+		if len(path) > 1 {
+			prevChar = path[len(path)-2]
+		}
+		current = nextRow(q, prevRow, row, prevChar, path[len(path)-1])
+	} else {
+		row = nil
+	}
+	edits := current[len(q)]
+	if prefix {
+		prefixEdits = minInt(prefixEdits, edits)
+		edits = prefixEdits
+	}
+	if n.word && edits <= maxEdits {
+		if previous, ok := results[string(path)]; !ok || edits < previous {
+			results[string(path)] = edits
+		}
+	}
+	// no word below can get closer than the best cell of the row
+	closest := current[0]
+	for _, d := range current {
+		closest = minInt(closest, d)
+	}
+	if closest > maxEdits && !(prefix && prefixEdits <= maxEdits) {
+		return
+	}
+	for char, child := range n.children {
+		child.searchEdits(q, append(path, char), row, current, prefixEdits, maxEdits, prefix, results)
+	}
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -2,6 +2,7 @@ package index
 
 import (
 	"strings"
+	"sync"
 
 	"github.com/txominpelu/fnd/search"
 )
@@ -31,41 +32,84 @@ func CommandLineTokenizer() Tokenizer {
 }
 
 // Glossary
-// Word2Doc: mapping from word to docs containing it
+// Word2Doc: mapping from word to docs containing it (and how many times)
 // PerFieldWord2Doc: mapping from field to word2doc
+//   from field to the trie with the words of that field
+//   and from field to the number of words of each doc (for ranking)
 
-type Word2Doc = map[string]map[int]bool
+type Word2Doc = map[string]map[int]int
 
 type PerFieldWord2Doc struct {
 	perfieldWord2Doc map[string]Word2Doc
+	perfieldTrie     map[string]*trie
+	perfieldLength   map[string]map[int]int
+	// sum of perfieldLength
+	perfieldTotalLength map[string]int
 }
 
+// Options tune what gets indexed for every token
+type Options struct {
+	// ngrams of length between NgramMin and NgramMax are indexed so that
+	// part of a token also matches. NgramMax 0 disables ngrams
+	NgramMin int
+	NgramMax int
+	// EdgeNgrams only indexes the ngrams at the start of the token
+	EdgeNgrams bool
+	// Prefix makes a query match all the tokens that start with it
+	Prefix bool
+	// MaxEdits makes a query match the tokens that are up to MaxEdits typos away,
+	// short queries allow less (see allowedEdits). 0 disables it
+	MaxEdits int
+	// Tokenizers per field, the fields that are not here use the tokenizer of the searcher
+	Tokenizers map[string]Tokenizer
+	// KeepAccents only lower cases the words instead of normalizing them (see search.Normalize)
+	KeepAccents bool
+}
+
+// IndexedLines is safe for one goroutine adding documents while others query it.
+// Queries hold a read lock while they look up the index.
 type IndexedLines struct {
+	mutex     sync.RWMutex
 	count     int
 	index     PerFieldWord2Doc
 	docs      []search.Document
 	docIds    []int
 	tokenizer Tokenizer
+	options   Options
 }
 
 func NewIndexedLines(tokenizer Tokenizer) *IndexedLines {
+	return NewIndexedLinesWithOptions(tokenizer, Options{})
+}
+
+func NewIndexedLinesWithOptions(tokenizer Tokenizer, options Options) *IndexedLines {
 	i := IndexedLines{}
 	if i.index.perfieldWord2Doc == nil {
-		i.index = PerFieldWord2Doc{perfieldWord2Doc: map[string]Word2Doc{}}
+		i.index = PerFieldWord2Doc{
+			perfieldWord2Doc:    map[string]Word2Doc{},
+			perfieldTrie:        map[string]*trie{},
+			perfieldLength:      map[string]map[int]int{},
+			perfieldTotalLength: map[string]int{},
+		}
 	}
 	i.tokenizer = tokenizer
+	i.options = options
 	return &i
 }
 
 func (i *IndexedLines) AddDocument(doc search.Document) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
 	docId := i.count // docId = index in array
 	i.docs = append(i.docs, doc)
 	i.docIds = append(i.docIds, docId)
-	index(doc.ParsedLine, &(i.index.perfieldWord2Doc), docId, i.tokenizer)
+	index(doc.ParsedLine, &(i.index), docId, i.tokenizer, i.options)
 	i.count++
 }
 
 func (i *IndexedLines) GetDocById(docId int) search.Document {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
 	return i.docs[docId]
 }
 
@@ -78,45 +122,132 @@ func (i *IndexedLines) GetDocById(docId int) search.Document {
 //        indexElem(elem)
 //   else:
 //      ignore element -> it ignores null, numbers and nested objects
-func index(parsedLine map[string]string, perfield *map[string]Word2Doc, docId int, tokenizer Tokenizer) {
+func index(parsedLine map[string]string, perfield *PerFieldWord2Doc, docId int, tokenizer Tokenizer, options Options) {
 	for key, val := range parsedLine {
-		indexElem(perfield, key, val, docId, tokenizer)
+		indexElem(perfield, key, val, docId, fieldTokenizer(key, tokenizer, options), options)
+	}
+}
+
+// fieldTokenizer returns the tokenizer configured for the field, or tokenizer if there's none
+func fieldTokenizer(field string, tokenizer Tokenizer, options Options) Tokenizer {
+	if t, ok := options.Tokenizers[field]; ok {
+		return t
 	}
+	return tokenizer
 }
 
-func indexElem(perfield *map[string]Word2Doc, key string, val interface{}, docId int, tokenizer Tokenizer) {
+func indexElem(perfield *PerFieldWord2Doc, key string, val interface{}, docId int, tokenizer Tokenizer, options Options) {
 	switch val.(type) {
 	case string:
-		indexLine(perfield, key, val.(string), docId, tokenizer)
+		indexLine(perfield, key, val.(string), docId, tokenizer, options)
+	}
+}
+
+// indexLine indexes every token of the line, only the tokens that are not parts of another one
+// (see groupTokens) count for the length of the field, and a word or ngram counts once for each of them
+func indexLine(perfield *PerFieldWord2Doc, field string, line string, docId int, tokenizer Tokenizer, options Options) {
+	groups := groupTokens(tokenizer(line), tokenizer)
+	if _, ok := perfield.perfieldLength[field]; !ok {
+		perfield.perfieldLength[field] = map[int]int{}
+	}
+	perfield.perfieldLength[field][docId] = len(groups)
+	perfield.perfieldTotalLength[field] += len(groups)
+	for _, group := range groups {
+		// the words and ngrams of the token and its parts, main.go and its part main share mai, ain...
+		indexed := map[string]bool{}
+		for _, word := range group {
+			word = lowerWord(word, options)
+			if !indexed[word] && (options.Prefix || options.MaxEdits > 0) {
+				addToTrie(perfield, field, word)
+			}
+			indexed[word] = true
+			for _, ngram := range findNgrams(word, options.NgramMin, options.NgramMax, options.EdgeNgrams) {
+				indexed[ngram] = true
+			}
+		}
+		for word := range indexed {
+			addWord(perfield, field, word, docId)
+		}
 	}
 }
 
-func indexLine(perfield *map[string]Word2Doc, field string, line string, docId int, tokenizer Tokenizer) {
-	for _, word := range tokenizer(line) {
-		addWord(perfield, field, word, docId)
-		//FIXME: add ngrams
-		for _, ngram := range findNgrams(word, 0, 0) {
-			addWord(perfield, field, ngram, docId)
+// groupTokens groups each token with the parts of it that the tokenizer returned right after it
+// (main after main.go for PathTokenizer, get and HTTP after getHTTP for CamelCaseTokenizer):
+// the parts are the other tokens that the tokenizer returns for the token alone
+func groupTokens(tokens []string, tokenizer Tokenizer) [][]string {
+	groups := [][]string{}
+	for i := 0; i < len(tokens); {
+		size := 1
+		if group := tokenizer(tokens[i]); len(group) > 1 && group[0] == tokens[i] && startsWith(tokens[i:], group) {
+			size = len(group)
 		}
+		groups = append(groups, tokens[i:i+size])
+		i += size
 	}
+	return groups
 }
 
-func addWord(perfieldPointer *map[string]Word2Doc, field string, word string, docId int) {
-	perfield := *perfieldPointer
+func startsWith(tokens []string, prefix []string) bool {
+	if len(prefix) > len(tokens) {
+		return false
+	}
+	for i, token := range prefix {
+		if tokens[i] != token {
+			return false
+		}
+	}
+	return true
+}
+
+func addWord(perfieldPointer *PerFieldWord2Doc, field string, word string, docId int) {
+	perfield := perfieldPointer.perfieldWord2Doc
 	word = strings.ToLower(word)
 	if _, ok := perfield[field]; !ok {
-		perfield[field] = map[string]map[int]bool{}
+		perfield[field] = map[string]map[int]int{}
 	}
 	if _, ok2 := perfield[field][word]; !ok2 {
-		perfield[field][word] = map[int]bool{}
+		perfield[field][word] = map[int]int{}
+	}
+	perfield[field][word][docId]++
+}
+
+func addToTrie(perfield *PerFieldWord2Doc, field string, word string) {
+	if _, ok := perfield.perfieldTrie[field]; !ok {
+		perfield.perfieldTrie[field] = newTrie()
 	}
-	perfield[field][word][docId] = true
+	perfield.perfieldTrie[field].insert(strings.ToLower(word))
 }
 
-func findNgrams(toLower string, min int, max int) []string {
-	return []string{}
+// lowerWord is applied to the words of the documents, queries are already lowered by their parser
+func lowerWord(word string, options Options) string {
+	if options.KeepAccents {
+		return strings.ToLower(word)
+	}
+	return search.Normalize(word)
+}
+
+// findNgrams returns the substrings of word with a length between min and max runes.
+// The word itself is not included since it's always indexed.
+// If edge is true only the substrings at the start of the word are returned.
+func findNgrams(word string, min int, max int, edge bool) []string {
+	ngrams := []string{}
+	runes := []rune(word)
+	if min < 1 {
+		min = 1
+	}
+	for n := min; n <= max && n < len(runes); n++ {
+		for start := 0; start+n <= len(runes); start++ {
+			ngrams = append(ngrams, string(runes[start:start+n]))
+			if edge {
+				break
+			}
+		}
+	}
+	return ngrams
 }
 
-func (i IndexedLines) Count() int {
+func (i *IndexedLines) Count() int {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
 	return i.count
 }
@@ -1,6 +1,7 @@
 package index
 
 import (
+	"fmt"
 	"reflect"
 	"testing"
 
@@ -24,6 +25,7 @@ func TestBasicQuery(t *testing.T) {
 	gotDocs := search.SortDocuments(
 		indexedLines.FilterEntries(search.ParseQuery("world")),
 		indexedLines,
+		func(d1 int, d2 int) bool { return d1 < d2 },
 	)
 	got := make([]string, len(gotDocs))
 	for i, d := range gotDocs {
@@ -48,3 +50,469 @@ func TestTokenizer(t *testing.T) {
 	}
 
 }
+
+func TestNgramQuery(t *testing.T) {
+	indexedLines := NewIndexedLinesWithOptions(CommandLineTokenizer(), Options{NgramMin: 2, NgramMax: 4})
+	lines := []string{
+		"hello world",
+		"this is the best WOrld",
+		"this won't match",
+	}
+	for _, l := range lines {
+		indexedLines.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	expected := []string{
+		"hello world",
+		"this is the best WOrld",
+	}
+	gotDocs := search.SortDocuments(
+		indexedLines.FilterEntries(search.ParseQuery("orl")),
+		indexedLines,
+		func(d1 int, d2 int) bool { return d1 < d2 },
+	)
+	got := make([]string, len(gotDocs))
+	for i, d := range gotDocs {
+		got[i] = d.RawText
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, got)
+	}
+}
+
+func TestFindNgrams(t *testing.T) {
+	expected := []string{"w", "wo", "wor"}
+	got := findNgrams("word", 1, 5, true)
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, got)
+	}
+	expected = []string{"wo", "or", "rd", "wor", "ord"}
+	got = findNgrams("word", 2, 3, false)
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, got)
+	}
+}
+
+func TestPrefixQuery(t *testing.T) {
+	indexedLines := NewIndexedLinesWithOptions(CommandLineTokenizer(), Options{Prefix: true})
+	lines := []string{
+		"hello world",
+		"this is the best WOrld",
+		"this won't match",
+		"wordle",
+	}
+	for _, l := range lines {
+		indexedLines.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	expected := []string{
+		"hello world",
+		"this is the best WOrld",
+		"this won't match",
+		"wordle",
+	}
+	gotDocs := search.SortDocuments(
+		indexedLines.FilterEntries(search.ParseQuery("wo")),
+		indexedLines,
+		func(d1 int, d2 int) bool { return d1 < d2 },
+	)
+	got := make([]string, len(gotDocs))
+	for i, d := range gotDocs {
+		got[i] = d.RawText
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, got)
+	}
+	expected = []string{
+		"this is the best WOrld",
+	}
+	gotDocs = search.SortDocuments(
+		indexedLines.FilterEntries(search.ParseQuery("wor this")),
+		indexedLines,
+		func(d1 int, d2 int) bool { return d1 < d2 },
+	)
+	got = make([]string, len(gotDocs))
+	for i, d := range gotDocs {
+		got[i] = d.RawText
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, got)
+	}
+}
+
+func TestExtendedQuery(t *testing.T) {
+	indexedLines := NewIndexedLinesWithOptions(CommandLineTokenizer(), Options{Prefix: true})
+	lines := []string{
+		"main.go",
+		"main_test.go",
+		"README.md",
+		"cmd/root.go",
+	}
+	for _, l := range lines {
+		indexedLines.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	cases := map[string][]string{
+		"go !test":      {"main.go", "cmd/root.go"},
+		"^main":         {"main.go", "main_test.go"},
+		"root | md":     {"README.md", "cmd/root.go"},
+		"go$ 'ain":      {"main.go", "main_test.go"},
+		"^cmd/root.go$": {"cmd/root.go"},
+	}
+	for query, expected := range cases {
+		gotDocs := search.SortDocuments(
+			indexedLines.FilterEntries(search.ParseQuery(query)),
+			indexedLines,
+			func(d1 int, d2 int) bool { return d1 < d2 },
+		)
+		got := make([]string, len(gotDocs))
+		for i, d := range gotDocs {
+			got[i] = d.RawText
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Query '%s' expected: '%v' but got '%v'", query, expected, got)
+		}
+	}
+}
+
+func TestNumericQuery(t *testing.T) {
+	indexedLines := NewIndexedLinesWithOptions(CommandLineTokenizer(), Options{Prefix: true})
+	parser := search.TabularParser([]string{"USER", "PID", "%CPU", "COMMAND"}, ' ')
+	lines := []string{
+		"root 1 0.0 /sbin/init",
+		"me 1234 12.5 firefox",
+		"me 99 n/a defunct",
+	}
+	for _, l := range lines {
+		indexedLines.AddDocument(search.ParseLine(parser, l))
+	}
+	cases := map[string][]string{
+		"%CPU:>10":          {"me 1234 12.5 firefox"},
+		"PID:<1000 USER:me": {"me 99 n/a defunct"},
+		"PID:1..99":         {"root 1 0.0 /sbin/init", "me 99 n/a defunct"},
+	}
+	for query, expected := range cases {
+		gotDocs := search.SortDocuments(
+			indexedLines.FilterEntries(search.ParseQuery(query)),
+			indexedLines,
+			func(d1 int, d2 int) bool { return d1 < d2 },
+		)
+		got := make([]string, len(gotDocs))
+		for i, d := range gotDocs {
+			got[i] = d.RawText
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Query '%s' expected: '%v' but got '%v'", query, expected, got)
+		}
+	}
+}
+
+func TestConcurrentAddAndQuery(t *testing.T) {
+	indexedLines := NewIndexedLinesWithOptions(CommandLineTokenizer(), Options{Prefix: true, NgramMin: 2, NgramMax: 3})
+	total := 2000
+	done := make(chan bool)
+	go func() {
+		for i := 0; i < total; i++ {
+			indexedLines.AddDocument(search.ParseLine(search.PlainTextParser(), fmt.Sprintf("line %d", i)))
+		}
+		close(done)
+	}()
+	subQueries := search.ParseQuery("lin 1 !'0")
+	for finished := false; !finished; {
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+		docIds := indexedLines.FilterEntries(subQueries)
+		docs := search.SortDocuments(docIds, indexedLines, func(d1 int, d2 int) bool { return d1 < d2 })
+		for _, d := range docs {
+			indexedLines.MatchPositions(d, subQueries)
+		}
+		if len(docIds) > indexedLines.Count() {
+			t.Fatalf("Got %d results with only %d documents", len(docIds), indexedLines.Count())
+		}
+	}
+	if got := len(indexedLines.FilterEntries([]search.SubQuery{})); got != total {
+		t.Errorf("Expected: '%d' documents but got '%d'", total, got)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		query    string
+		word     string
+		prefix   bool
+		expected int
+	}{
+		{"kubectl", "kubectl", false, 0},
+		{"kubctl", "kubectl", false, 1},
+		{"gti", "git", false, 1},
+		{"dokcer", "docker", false, 1},
+		{"kubc", "kubectl", true, 1},
+		{"kubc", "kubectl", false, 3},
+		{"abc", "", false, 3},
+	}
+	for _, c := range cases {
+		got := editDistance([]rune(c.query), []rune(c.word), c.prefix)
+		if got != c.expected {
+			t.Errorf("%s -> %s: Expected: '%v' but got '%v'", c.query, c.word, c.expected, got)
+		}
+	}
+}
+
+func TestTypoQuery(t *testing.T) {
+	indexedLines := NewIndexedLinesWithOptions(CommandLineTokenizer(), Options{MaxEdits: 2})
+	lines := []string{
+		"kubectl get pods",
+		"git status",
+		"kubeadm init",
+		"kubctl logs",
+	}
+	for _, l := range lines {
+		indexedLines.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{"kubctl", []string{"kubectl get pods", "kubctl logs"}},
+		{"gti", []string{"git status"}},
+		// too short to allow typos
+		{"gt", []string{}},
+		{"kubeadn", []string{"kubeadm init"}},
+	}
+	for _, c := range cases {
+		gotDocs := search.SortDocuments(
+			indexedLines.FilterEntries(search.ParseQuery(c.query)),
+			indexedLines,
+			func(d1 int, d2 int) bool { return d1 < d2 },
+		)
+		got := []string{}
+		for _, d := range gotDocs {
+			got = append(got, d.RawText)
+		}
+		if !reflect.DeepEqual(c.expected, got) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", c.query, c.expected, got)
+		}
+	}
+	// fewer edits rank first
+	subQueries := search.ParseQuery("kubctl")
+	if exact, typo := indexedLines.Score(3, subQueries), indexedLines.Score(0, subQueries); exact <= typo {
+		t.Errorf("Expected the exact match to score more than the typo (%v <= %v)", exact, typo)
+	}
+	positions := indexedLines.MatchPositions(indexedLines.GetDocById(0), subQueries)
+	expected := search.MatchPositions{"$": []search.Range{{Start: 0, End: 7}}}
+	if !reflect.DeepEqual(expected, positions) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, positions)
+	}
+}
+
+func TestTypoPrefixQuery(t *testing.T) {
+	indexedLines := NewIndexedLinesWithOptions(CommandLineTokenizer(), Options{MaxEdits: 1, Prefix: true})
+	for _, l := range []string{"kubectl get pods", "git status"} {
+		indexedLines.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	got := indexedLines.FilterEntries(search.ParseQuery("kubc"))
+	if !reflect.DeepEqual([]int{0}, got) {
+		t.Errorf("Expected: '%v' but got '%v'", []int{0}, got)
+	}
+}
+
+func TestRelevance(t *testing.T) {
+	indexedLines := NewIndexedLinesWithOptions(CommandLineTokenizer(), Options{Prefix: true})
+	lines := []string{
+		"the package manager installs the packages",
+		"the docker daemon runs the containers of the docker clients and many other things",
+		"the docker docker container",
+		"the manual of docker",
+	}
+	for _, l := range lines {
+		indexedLines.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	relevance := indexedLines.Relevance(search.ParseQuery("the docker"))
+	docIds := indexedLines.FilterEntries(search.ParseQuery("the docker"))
+	sortByRelevance := func(d1 int, d2 int) bool { return relevance(d1) > relevance(d2) }
+	got := []string{}
+	for _, d := range search.SortDocuments(docIds, indexedLines, sortByRelevance) {
+		got = append(got, d.RawText)
+	}
+	// a short field mentioning docker beats a long one mentioning it twice
+	expected := []string{
+		"the docker docker container",
+		"the manual of docker",
+		"the docker daemon runs the containers of the docker clients and many other things",
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, got)
+	}
+	// the rare word counts more than the common one
+	if docker, the := indexedLines.Relevance(search.ParseQuery("docker"))(3), indexedLines.Relevance(search.ParseQuery("the"))(3); docker <= the {
+		t.Errorf("Expected docker to be more relevant than the (%v <= %v)", docker, the)
+	}
+	// repeating the word makes the document more relevant
+	docker := indexedLines.Relevance(search.ParseQuery("docker"))
+	if repeated, once := docker(2), docker(3); repeated <= once {
+		t.Errorf("Expected repetitions to be more relevant (%v <= %v)", repeated, once)
+	}
+	if got := indexedLines.Relevance(search.ParseQuery("apt"))(0); got != 0 {
+		t.Errorf("Expected: '%v' but got '%v'", 0, got)
+	}
+}
+
+func TestTokenizers(t *testing.T) {
+	cases := []struct {
+		name     string
+		text     string
+		expected []string
+	}{
+		{"whitespace", " a.b  c/d\te ", []string{"a.b", "c/d", "e"}},
+		{"path", "src/index/main.go C:\\tmp\\.bashrc", []string{"src", "index", "main.go", "main", "C:", "tmp", ".bashrc"}},
+		{"camel", "getHTTPResponse_code kube-proxy x", []string{"getHTTPResponse_code", "get", "HTTP", "Response", "code", "kube-proxy", "kube", "proxy", "x"}},
+		{"words", "Straße, naïve café: 東京-2020!", []string{"Straße", "naïve", "café", "東京", "2020"}},
+		{"ngram", "hello ab", []string{"hello", "hel", "ell", "llo", "ab"}},
+	}
+	for _, c := range cases {
+		tokenizer, err := TokenizerByName(c.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tokenizer(c.text); !reflect.DeepEqual(c.expected, got) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", c.name, c.expected, got)
+		}
+	}
+	if _, err := TokenizerByName("unknown"); err == nil {
+		t.Errorf("Expected an error for an unknown tokenizer")
+	}
+}
+
+func TestFieldTokenizers(t *testing.T) {
+	options := Options{Tokenizers: map[string]Tokenizer{"file": PathTokenizer()}}
+	indexedLines := NewIndexedLinesWithOptions(WhitespaceTokenizer(), options)
+	docs := []map[string]string{
+		{"file": "cmd/root.go", "content": "func getSearcher(searchType string)"},
+		{"file": "search/index/query.go", "content": "root.go is in cmd"},
+	}
+	for _, d := range docs {
+		indexedLines.AddDocument(search.Document{RawText: d["file"], ParsedLine: d, LoweredParsed: d})
+	}
+	cases := []struct {
+		query    string
+		expected []int
+	}{
+		{"file:root.go", []int{0}},
+		{"file:root", []int{0}},
+		{"file:cmd", []int{0}},
+		{"content:root.go", []int{1}},
+		{"content:root", []int{}},
+	}
+	for _, c := range cases {
+		if got := indexedLines.FilterEntries(search.ParseQuery(c.query)); !reflect.DeepEqual(c.expected, got) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", c.query, c.expected, got)
+		}
+	}
+}
+
+func TestTokenPartsDontCountForRelevance(t *testing.T) {
+	indexedLines := NewIndexedLines(CamelCaseTokenizer())
+	for _, l := range []string{"getHTTPResponse", "get response", "getX get"} {
+		indexedLines.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	expectedLengths := map[int]int{0: 1, 1: 2, 2: 2}
+	if got := indexedLines.index.perfieldLength["$"]; !reflect.DeepEqual(expectedLengths, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expectedLengths, got)
+	}
+	expectedTf := map[int]int{0: 1, 1: 1, 2: 2}
+	if got := indexedLines.index.perfieldWord2Doc["$"]["get"]; !reflect.DeepEqual(expectedTf, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expectedTf, got)
+	}
+	relevance := indexedLines.Relevance(search.ParseQuery("response"))
+	if relevance(0) <= relevance(1) {
+		t.Errorf("Expected getHTTPResponse (%v) to be more relevant than 'get response' (%v)", relevance(0), relevance(1))
+	}
+}
+
+func TestTokenPartsShareNgrams(t *testing.T) {
+	indexedLines := NewIndexedLinesWithOptions(PathTokenizer(), Options{NgramMin: 2, NgramMax: 5})
+	indexedLines.AddDocument(search.ParseLine(search.PlainTextParser(), "src/main.go"))
+	for _, word := range []string{"main", "mai", "ma", "main.go", "src"} {
+		if got := indexedLines.index.perfieldWord2Doc["$"][word][0]; got != 1 {
+			t.Errorf("%s: Expected: '%v' but got '%v'", word, 1, got)
+		}
+	}
+}
+
+func TestMergedMatchPositions(t *testing.T) {
+	indexedLines := NewIndexedLinesWithOptions(CommandLineTokenizer(), Options{Prefix: true, MaxEdits: 1})
+	indexedLines.AddDocument(search.ParseLine(search.PlainTextParser(), "kube kubectl apply"))
+	cases := map[string][]search.Range{
+		"kub":    {{Start: 0, End: 4}, {Start: 5, End: 12}},
+		"kube":   {{Start: 0, End: 4}, {Start: 5, End: 12}},
+		"kubctl": {{Start: 5, End: 12}},
+		"aply":   {{Start: 13, End: 18}},
+	}
+	for query, expected := range cases {
+		positions := indexedLines.MatchPositions(indexedLines.GetDocById(0), search.ParseQuery(query))
+		if !reflect.DeepEqual(expected, positions["$"]) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", query, expected, positions["$"])
+		}
+	}
+}
+
+func TestIgnoresAccents(t *testing.T) {
+	lines := []string{"Café au lait", "cafeteria"}
+	indexedLines := NewIndexedLinesWithOptions(CommandLineTokenizer(), Options{})
+	keepAccents := NewIndexedLinesWithOptions(CommandLineTokenizer(), Options{KeepAccents: true})
+	for _, l := range lines {
+		indexedLines.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+		keepAccents.AddDocument(search.ParseLine(search.PlainTextParser().KeepAccents(), l))
+	}
+	if got := indexedLines.FilterEntries(search.ParseQuery("cafe")); !reflect.DeepEqual([]int{0}, got) {
+		t.Errorf("Expected: '%v' but got '%v'", []int{0}, got)
+	}
+	subQueries, _ := search.QueryParser{KeepAccents: true}.Parse("cafe")
+	if got := keepAccents.FilterEntries(subQueries); !reflect.DeepEqual([]int{}, got) {
+		t.Errorf("Expected: '%v' but got '%v'", []int{}, got)
+	}
+}
+
+func TestSmartCase(t *testing.T) {
+	indexedLines := NewIndexedLinesWithOptions(CommandLineTokenizer(), Options{Prefix: true, MaxEdits: 1})
+	for _, l := range []string{"Docker run", "docker ps", "DOCKER images"} {
+		indexedLines.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	cases := map[string][]int{
+		"docker": {0, 1, 2},
+		"Dock":   {0},
+		"DOCKER": {2},
+		"Dokcer": {0},
+	}
+	for query, expected := range cases {
+		subQueries, _ := search.QueryParser{Case: search.CaseSmart}.Parse(query)
+		if got := indexedLines.FilterEntries(subQueries); !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", query, expected, got)
+		}
+	}
+	subQueries, _ := search.QueryParser{Case: search.CaseSmart}.Parse("DOCKER")
+	positions := indexedLines.MatchPositions(indexedLines.GetDocById(2), subQueries)
+	expected := search.MatchPositions{"$": []search.Range{{Start: 0, End: 6}}}
+	if !reflect.DeepEqual(expected, positions) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, positions)
+	}
+}
+
+func TestSmartCaseIgnoresAccents(t *testing.T) {
+	indexedLines := NewIndexedLines(CommandLineTokenizer())
+	for _, l := range []string{"Cafe de Flore", "café noir", "Café crème"} {
+		indexedLines.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	for _, query := range []string{"Cafe", "Café", "'Cafe"} {
+		subQueries, _ := search.QueryParser{Case: search.CaseSmart}.Parse(query)
+		expected := []int{0, 2}
+		if got := indexedLines.FilterEntries(subQueries); !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", query, expected, got)
+		}
+		positions := indexedLines.MatchPositions(indexedLines.GetDocById(2), subQueries)
+		expectedPositions := search.MatchPositions{"$": []search.Range{{Start: 0, End: 4}}}
+		if !reflect.DeepEqual(expectedPositions, positions) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", query, expectedPositions, positions)
+		}
+	}
+}
@@ -1,34 +1,277 @@
 package index
 
 import (
+	"math"
+	"sort"
 	"strings"
 
 	"github.com/txominpelu/fnd/search"
 )
 
-// Query. Return docIds
-func (i IndexedLines) FilterEntries(subQueries []search.SubQuery) []int {
+// Query. Return docIds in increasing order
+// subqueries that can be answered with the index are resolved first,
+// the rest (negations, prefix/suffix...) are checked only against those results
+func (i *IndexedLines) FilterEntries(subQueries []search.SubQuery) []int {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
 	if len(subQueries) > 0 {
-		results := i.index.perfieldWord2Doc[subQueries[0].Field][strings.ToLower(subQueries[0].Query)]
-		for _, sQ := range subQueries[1:] {
-			if docs, ok := i.index.perfieldWord2Doc[sQ.Field][strings.ToLower(sQ.Query)]; ok {
-				results = intersection(results, docs)
+		var results map[int]bool
+		others := []search.SubQuery{}
+		for _, sQ := range subQueries {
+			if !indexable(sQ) {
+				others = append(others, sQ)
+				continue
+			}
+			if docs := i.docsMatching(sQ); len(docs) > 0 {
+				if results == nil {
+					results = docs
+				} else {
+					results = intersection(results, docs)
+				}
 			} else {
 				return []int{}
 			}
 		}
-		docIds := make([]int, len(results))
-		{
+		var docIds []int
+		if results == nil {
+			docIds = make([]int, len(i.docIds))
+			copy(docIds, i.docIds)
+		} else {
+			docIds = make([]int, len(results))
 			j := 0
 			for dId := range results {
 				docIds[j] = dId
 				j++
 			}
+			// same order as the documents were added, whatever the order of the map
+			sort.Ints(docIds)
+		}
+		for _, sQ := range others {
+			docIds = i.filter(docIds, sQ)
 		}
 		return docIds
 	}
 	// otherwise if no query all docs match
-	return i.docIds
+	docIds := make([]int, len(i.docIds))
+	copy(docIds, i.docIds)
+	return docIds
+}
+
+// indexable is true if all the alternatives of the subquery are plain words
+func indexable(sQ search.SubQuery) bool {
+	for _, alt := range sQ.Alternatives() {
+		if alt.Kind != search.Fuzzy || alt.Negated {
+			return false
+		}
+	}
+	return true
+}
+
+// docsMatching returns the union of the docs matching each alternative of the subquery
+func (i *IndexedLines) docsMatching(sQ search.SubQuery) map[int]bool {
+	if len(sQ.Or) == 0 {
+		return i.docsMatchingWord(sQ)
+	}
+	results := map[int]bool{}
+	for _, alt := range sQ.Alternatives() {
+		for dId := range i.docsMatchingWord(alt) {
+			results[dId] = true
+		}
+	}
+	return results
+}
+
+// docsMatchingWord returns the docs that contain any of the words matched by the subquery
+func (i *IndexedLines) docsMatchingWord(sQ search.SubQuery) map[int]bool {
+	word2Doc := i.index.perfieldWord2Doc[sQ.Field]
+	results := map[int]bool{}
+	for word := range i.matchingWords(sQ) {
+		for dId := range word2Doc[word] {
+			if !sQ.CaseSensitive || results[dId] || i.matchesCase(i.docs[dId], sQ) {
+				results[dId] = true
+			}
+		}
+	}
+	return results
+}
+
+// matchesCase tells if a case sensitive subquery matches a token of the field as written,
+// the index only has the lowered tokens
+func (i *IndexedLines) matchesCase(doc search.Document, sQ search.SubQuery) bool {
+	query := i.comparable(sQ.Query, sQ)
+	for _, token := range fieldTokenizer(sQ.Field, i.tokenizer, i.options)(doc.ParsedLine[sQ.Field]) {
+		if i.options.NgramMax > 0 && strings.Contains(i.comparable(token, sQ), query) {
+			return true
+		}
+		if _, ok := i.tokenEdits(token, sQ); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenEdits returns the typos to turn a token of the document into the word of the subquery
+// and if they are allowed. For case sensitive subqueries a different case is not a typo,
+// the token has to need the same edits than when ignoring case
+func (i *IndexedLines) tokenEdits(token string, sQ search.SubQuery) (int, bool) {
+	q := []rune(lowerWord(sQ.Query, i.options))
+	edits := editDistance(q, []rune(lowerWord(token, i.options)), i.options.Prefix)
+	if edits > allowedEdits(q, i.options.MaxEdits) {
+		return edits, false
+	}
+	if sQ.CaseSensitive && editDistance([]rune(i.comparable(sQ.Query, sQ)), []rune(i.comparable(token, sQ)), i.options.Prefix) != edits {
+		return edits, false
+	}
+	return edits, true
+}
+
+// comparable is how the words of the query and of the documents are compared for the subquery:
+// lowered (see lowerWord) or, for case sensitive subqueries, only without accents
+func (i *IndexedLines) comparable(word string, sQ search.SubQuery) string {
+	if !sQ.CaseSensitive {
+		return lowerWord(word, i.options)
+	}
+	if i.options.KeepAccents {
+		return word
+	}
+	folded, _ := search.FoldAccents(word)
+	return string(folded)
+}
+
+// matchingWords returns the words of the field matched by the subquery with the typos needed to match them:
+// the word itself, when prefix search is enabled the words starting with it
+// and, when typos are allowed, the words close enough to it
+func (i *IndexedLines) matchingWords(sQ search.SubQuery) map[string]int {
+	query := lowerWord(sQ.Query, i.options)
+	words := map[string]int{query: 0}
+	t, ok := i.index.perfieldTrie[sQ.Field]
+	if !ok {
+		return words
+	}
+	if i.options.MaxEdits > 0 {
+		for word, edits := range t.withinEdits(query, allowedEdits([]rune(query), i.options.MaxEdits), i.options.Prefix) {
+			if _, ok := words[word]; !ok {
+				words[word] = edits
+			}
+		}
+	} else if i.options.Prefix {
+		for _, word := range t.withPrefix(query) {
+			words[word] = 0
+		}
+	}
+	return words
+}
+
+// filter keeps the docs for which any alternative of the subquery matches
+func (i *IndexedLines) filter(docIds []int, sQ search.SubQuery) []int {
+	alternatives := sQ.Alternatives()
+	// plain words are still looked up in the index
+	wordDocs := make([]map[int]bool, len(alternatives))
+	for j, alt := range alternatives {
+		if alt.Kind == search.Fuzzy {
+			wordDocs[j] = i.docsMatchingWord(alt)
+		}
+	}
+	result := []int{}
+	for _, dId := range docIds {
+		doc := i.docs[dId]
+		for j, alt := range alternatives {
+			var ok bool
+			if alt.Kind == search.Fuzzy {
+				ok = wordDocs[j][dId] != alt.Negated
+			} else {
+				ok = search.MatchesAlternative(doc, alt)
+			}
+			if ok {
+				result = append(result, dId)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// MatchPositions returns every occurrence of the subqueries in their field,
+// the query word and the tokens it matched (by prefix or typos) can overlap so they are merged
+func (i *IndexedLines) MatchPositions(doc search.Document, subQueries []search.SubQuery) search.MatchPositions {
+	positions := search.MatchPositions{}
+	for _, sQ := range subQueries {
+		for _, alt := range sQ.Alternatives() {
+			if alt.Negated || alt.Kind == search.Where {
+				continue
+			}
+			if alt.Kind == search.Fuzzy {
+				find := search.FindAll
+				if alt.CaseSensitive {
+					find = search.FindExact
+				}
+				for _, word := range i.matchedWords(doc, alt) {
+					positions[alt.Field] = append(positions[alt.Field], find(doc.ParsedLine[alt.Field], word, alt.KeepAccents)...)
+				}
+			} else {
+				positions[alt.Field] = append(positions[alt.Field], search.FindMatches(doc.ParsedLine[alt.Field], alt)...)
+			}
+		}
+	}
+	for field, ranges := range positions {
+		positions[field] = search.MergeRanges(ranges)
+	}
+	return positions
+}
+
+// Score ranks first the documents that need fewer typos to match the subqueries (see Options.MaxEdits).
+// Higher is better, documents that don't match get the lowest possible score.
+func (i *IndexedLines) Score(docId int, subQueries []search.SubQuery) float64 {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	doc := i.docs[docId]
+	total := 0
+	for _, sQ := range subQueries {
+		if !indexable(sQ) {
+			continue
+		}
+		best := -1
+		for _, alt := range sQ.Alternatives() {
+			if edits, ok := i.edits(doc, docId, alt); ok && (best < 0 || edits < best) {
+				best = edits
+			}
+		}
+		if best < 0 {
+			return math.Inf(-1)
+		}
+		total += best
+	}
+	return float64(-total)
+}
+
+// edits returns the fewest typos between the word of the subquery and the tokens of its field
+func (i *IndexedLines) edits(doc search.Document, docId int, sQ search.SubQuery) (int, bool) {
+	if !sQ.CaseSensitive && i.index.perfieldWord2Doc[sQ.Field][lowerWord(sQ.Query, i.options)][docId] > 0 {
+		return 0, true
+	}
+	best := -1
+	for _, token := range fieldTokenizer(sQ.Field, i.tokenizer, i.options)(doc.ParsedLine[sQ.Field]) {
+		edits, ok := i.tokenEdits(token, sQ)
+		if ok && (best < 0 || edits < best) {
+			best = edits
+		}
+	}
+	return best, best >= 0
+}
+
+// matchedWords returns the query and, when typos are allowed, the tokens of the field close enough to it
+func (i *IndexedLines) matchedWords(doc search.Document, sQ search.SubQuery) []string {
+	query := i.comparable(sQ.Query, sQ)
+	words := []string{query}
+	if i.options.MaxEdits == 0 {
+		return words
+	}
+	for _, token := range fieldTokenizer(sQ.Field, i.tokenizer, i.options)(doc.ParsedLine[sQ.Field]) {
+		if _, ok := i.tokenEdits(token, sQ); ok && i.comparable(token, sQ) != query {
+			words = append(words, i.comparable(token, sQ))
+		}
+	}
+	return words
 }
 
 func intersection(s1 map[int]bool, s2 map[int]bool) map[int]bool {
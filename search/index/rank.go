@@ -0,0 +1,85 @@
+package index
+
+import (
+	"math"
+
+	"github.com/txominpelu/fnd/search"
+)
+
+// BM25 parameters, the usual defaults
+const (
+	// k1 controls how fast repeating a word stops making the document more relevant
+	k1 = 1.2
+	// b controls how much longer fields are penalized
+	b = 0.75
+)
+
+// weightedWord is a word matched by a subquery, weight is its idf lowered by the typos needed to match it
+type weightedWord struct {
+	word   string
+	weight float64
+}
+
+// Relevance ranks the documents with BM25: words that are rare in a field count more than common ones,
+// words repeated in a field count more (up to a limit) and shorter fields are preferred.
+// A word matched with typos counts less. Subqueries that are not plain words (see indexable) don't count.
+func (i *IndexedLines) Relevance(subQueries []search.SubQuery) func(docId int) float64 {
+	i.mutex.RLock()
+	// words of each alternative of each subquery
+	terms := [][][]weightedWord{}
+	fields := [][]string{}
+	for _, sQ := range subQueries {
+		if !indexable(sQ) {
+			continue
+		}
+		alternatives := [][]weightedWord{}
+		altFields := []string{}
+		for _, alt := range sQ.Alternatives() {
+			words := []weightedWord{}
+			for word, edits := range i.matchingWords(alt) {
+				if df := len(i.index.perfieldWord2Doc[alt.Field][word]); df > 0 {
+					words = append(words, weightedWord{word, i.idf(alt.Field, df) / float64(1+edits)})
+				}
+			}
+			alternatives = append(alternatives, words)
+			altFields = append(altFields, alt.Field)
+		}
+		terms = append(terms, alternatives)
+		fields = append(fields, altFields)
+	}
+	i.mutex.RUnlock()
+	return func(docId int) float64 {
+		i.mutex.RLock()
+		defer i.mutex.RUnlock()
+		total := 0.0
+		for t, alternatives := range terms {
+			best := 0.0
+			for a, words := range alternatives {
+				for _, w := range words {
+					best = math.Max(best, w.weight*i.saturation(fields[t][a], w.word, docId))
+				}
+			}
+			total += best
+		}
+		return total
+	}
+}
+
+// idf is higher for the words that appear in fewer documents
+func (i *IndexedLines) idf(field string, df int) float64 {
+	n := float64(len(i.index.perfieldLength[field]))
+	return math.Log(1 + (n-float64(df)+0.5)/(float64(df)+0.5))
+}
+
+// saturation grows with the times the word is in the field of the document
+// but never goes above k1 + 1, long fields need more repetitions
+func (i *IndexedLines) saturation(field string, word string, docId int) float64 {
+	tf := float64(i.index.perfieldWord2Doc[field][word][docId])
+	if tf == 0 {
+		return 0
+	}
+	lengths := i.index.perfieldLength[field]
+	avgLength := float64(i.index.perfieldTotalLength[field]) / float64(len(lengths))
+	length := float64(lengths[docId])
+	return tf * (k1 + 1) / (tf + k1*(1-b+b*length/avgLength))
+}
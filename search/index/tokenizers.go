@@ -0,0 +1,136 @@
+package index
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// tokenizers by the name used in the command line
+var tokenizers = map[string]func() Tokenizer{
+	"commandline": CommandLineTokenizer,
+	"whitespace":  WhitespaceTokenizer,
+	"path":        PathTokenizer,
+	"camel":       CamelCaseTokenizer,
+	"words":       WordsTokenizer,
+	"ngram":       func() Tokenizer { return NgramTokenizer(3) },
+}
+
+// TokenizerByName returns one of the registered tokenizers (see TokenizerNames)
+func TokenizerByName(name string) (Tokenizer, error) {
+	newTokenizer, ok := tokenizers[name]
+	if !ok {
+		return nil, fmt.Errorf("tokenizer should be one of (%s) it was '%s'", strings.Join(TokenizerNames(), " / "), name)
+	}
+	return newTokenizer(), nil
+}
+
+func TokenizerNames() []string {
+	names := []string{}
+	for name := range tokenizers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WhitespaceTokenizer splits on spaces, tabs and new lines
+func WhitespaceTokenizer() Tokenizer {
+	return strings.Fields
+}
+
+// PathTokenizer splits on path separators and spaces.
+// Dots don't split, but the name without extension is a token too: src/main.go -> src, main.go, main
+func PathTokenizer() Tokenizer {
+	return func(s string) []string {
+		results := []string{}
+		parts := strings.FieldsFunc(s, func(r rune) bool {
+			return r == '/' || r == '\\' || unicode.IsSpace(r)
+		})
+		for _, part := range parts {
+			results = append(results, part)
+			if dot := strings.LastIndex(part, "."); dot > 0 {
+				results = append(results, part[:dot])
+			}
+		}
+		return results
+	}
+}
+
+// CamelCaseTokenizer splits identifiers written in camelCase or snake_case
+// and keeps the whole identifier too: getHTTPResponse_code -> getHTTPResponse_code, get, HTTP, Response, code
+func CamelCaseTokenizer() Tokenizer {
+	return func(s string) []string {
+		results := []string{}
+		for _, identifier := range strings.FieldsFunc(s, isSeparator) {
+			parts := splitIdentifier(identifier)
+			if len(parts) > 1 {
+				results = append(results, identifier)
+			}
+			results = append(results, parts...)
+		}
+		return results
+	}
+}
+
+// separators between identifiers, '_' and '-' are handled inside the identifier
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-'
+}
+
+func splitIdentifier(identifier string) []string {
+	parts := []string{}
+	runes := []rune(identifier)
+	start := 0
+	flush := func(end int) {
+		if end > start {
+			parts = append(parts, string(runes[start:end]))
+		}
+	}
+	for i, r := range runes {
+		if r == '_' || r == '-' {
+			flush(i)
+			start = i + 1
+			continue
+		}
+		if i == start {
+			continue
+		}
+		prev := runes[i-1]
+		// aB starts a word and so does the B in ABc (HTTPResponse -> HTTP, Response)
+		lowerToUpper := unicode.IsUpper(r) && !unicode.IsUpper(prev)
+		acronymEnd := unicode.IsUpper(r) && unicode.IsUpper(prev) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if lowerToUpper || acronymEnd {
+			flush(i)
+			start = i
+		}
+	}
+	flush(len(runes))
+	return parts
+}
+
+// WordsTokenizer keeps the runs of letters, digits and combining marks of any script
+// and splits on everything else (spaces, punctuation, symbols)
+func WordsTokenizer() Tokenizer {
+	return func(s string) []string {
+		return strings.FieldsFunc(s, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsMark(r)
+		})
+	}
+}
+
+// NgramTokenizer returns every word and all its substrings of n runes,
+// so that any part of a word matches: hello -> hello, hel, ell, llo
+func NgramTokenizer(n int) Tokenizer {
+	return func(s string) []string {
+		results := []string{}
+		for _, word := range strings.Fields(s) {
+			results = append(results, word)
+			if len([]rune(word)) > n {
+				results = append(results, findNgrams(word, n, n, false)...)
+			}
+		}
+		return results
+	}
+}
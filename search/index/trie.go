@@ -0,0 +1,58 @@
+package index
+
+// trie keeps the vocabulary of a field so that all the words
+// starting with a given prefix can be found without indexing every prefix
+type trie struct {
+	root *trieNode
+}
+
+type trieNode struct {
+	children map[rune]*trieNode
+	// true if a word ends at this node
+	word bool
+}
+
+func newTrie() *trie {
+	return &trie{root: newTrieNode()}
+}
+
+func newTrieNode() *trieNode {
+	return &trieNode{children: map[rune]*trieNode{}}
+}
+
+func (t *trie) insert(word string) {
+	node := t.root
+	for _, r := range word {
+		child, ok := node.children[r]
+		if !ok {
+			child = newTrieNode()
+			node.children[r] = child
+		}
+		node = child
+	}
+	node.word = true
+}
+
+// withPrefix returns all the words in the trie that start with prefix
+func (t *trie) withPrefix(prefix string) []string {
+	node := t.root
+	for _, r := range prefix {
+		child, ok := node.children[r]
+		if !ok {
+			return []string{}
+		}
+		node = child
+	}
+	words := []string{}
+	node.collect([]rune(prefix), &words)
+	return words
+}
+
+func (n *trieNode) collect(path []rune, words *[]string) {
+	if n.word {
+		*words = append(*words, string(path))
+	}
+	for r, child := range n.children {
+		child.collect(append(path, r), words)
+	}
+}
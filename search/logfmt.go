@@ -0,0 +1,107 @@
+package search
+
+import (
+	"strconv"
+)
+
+// LogfmtParser parses lines of key=value pairs separated by spaces: level=info msg="request done" dur=12ms.
+// Quoted values can have spaces and escapes (\" \n), a key without value (bare key) is true
+func LogfmtParser(headers []string) Parser {
+	parse := func(line string) map[string]interface{} {
+		result := map[string]interface{}{}
+		for _, pair := range parseLogfmt(line) {
+			result[pair.key] = pair.value
+		}
+		return result
+	}
+	return Parser{
+		headers: headers,
+		parse:   parse,
+	}
+}
+
+// logfmtHeaders returns the keys of all the lines in the order they first appear
+func logfmtHeaders(lines []string) []string {
+	headers := []string{}
+	seen := map[string]bool{}
+	for _, line := range lines {
+		for _, pair := range parseLogfmt(line) {
+			if !seen[pair.key] {
+				seen[pair.key] = true
+				headers = append(headers, pair.key)
+			}
+		}
+	}
+	return headers
+}
+
+type logfmtPair struct {
+	key string
+	// string or true for bare keys
+	value interface{}
+}
+
+// parseLogfmt returns the pairs in the order they appear in the line, text that is not a pair is skipped
+func parseLogfmt(line string) []logfmtPair {
+	pairs := []logfmtPair{}
+	i := 0
+	for i < len(line) {
+		if isLogfmtSpace(line[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && !isLogfmtSpace(line[i]) {
+			i++
+		}
+		key := line[start:i]
+		if i >= len(line) || line[i] != '=' {
+			if key != "" {
+				pairs = append(pairs, logfmtPair{key: key, value: true})
+			}
+			continue
+		}
+		// skip '='
+		i++
+		var value string
+		if i < len(line) && line[i] == '"' {
+			value, i = readQuoted(line, i)
+		} else {
+			start = i
+			for i < len(line) && !isLogfmtSpace(line[i]) {
+				i++
+			}
+			value = line[start:i]
+		}
+		if key != "" {
+			pairs = append(pairs, logfmtPair{key: key, value: value})
+		}
+	}
+	return pairs
+}
+
+// readQuoted reads the quoted value that starts at line[start] and returns it unescaped
+// with the position after the closing quote. A value without closing quote takes the rest of the line
+func readQuoted(line string, start int) (string, int) {
+	i := start + 1
+	for i < len(line) {
+		switch line[i] {
+		case '\\':
+			i += 2
+			continue
+		case '"':
+			quoted := line[start : i+1]
+			if value, err := strconv.Unquote(quoted); err == nil {
+				return value, i + 1
+			}
+			return quoted[1 : len(quoted)-1], i + 1
+		}
+		i++
+	}
+	return line[start+1:], len(line)
+}
+
+// the line is read byte by byte, only ascii spaces separate the pairs
+func isLogfmtSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r' || b == '\n'
+}
@@ -0,0 +1,43 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLogfmtParser(t *testing.T) {
+	lines := []string{
+		`time=10:01 level=info msg="request done" status=200 dur=12ms`,
+		`time=10:02 level=error msg="failed: \"timeout\"" retry err=io.EOF`,
+	}
+	parser := FormatNameToParser("logfmt", lines, ParserOptions{}, nil)
+	expectedHeaders := []string{"time", "level", "msg", "status", "dur", "retry", "err"}
+	if !reflect.DeepEqual(expectedHeaders, parser.Headers()) {
+		t.Errorf("Expected: '%v' but got '%v'", expectedHeaders, parser.Headers())
+	}
+	doc := ParseLine(parser, lines[1])
+	expected := map[string]string{"time": "10:02", "level": "error", "msg": `failed: "timeout"`, "retry": "true", "err": "io.EOF"}
+	for field, value := range expected {
+		if doc.ParsedLine[field] != value {
+			t.Errorf("%s: Expected: '%v' but got '%v'", field, value, doc.ParsedLine[field])
+		}
+	}
+	if _, ok := doc.ParsedLine["status"]; ok {
+		t.Errorf("Expected no status field")
+	}
+	if !MatchesDocument(ParseLine(parser, lines[0]), ParseQuery("status:>=200")[0]) {
+		t.Errorf("Expected status:>=200 to match")
+	}
+}
+
+func TestLogfmtMalformed(t *testing.T) {
+	cases := map[string][]logfmtPair{
+		`a= b="unterminated`: {{key: "a", value: ""}, {key: "b", value: "unterminated"}},
+		`=x é=ü  c`:          {{key: "é", value: "ü"}, {key: "c", value: true}},
+	}
+	for line, expected := range cases {
+		if got := parseLogfmt(line); !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", line, expected, got)
+		}
+	}
+}
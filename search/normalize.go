@@ -0,0 +1,78 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalize lowers the text and removes accents and compatibility forms
+// so that café matches cafe and ｆｎｄ or ﬁnd match fnd
+func Normalize(text string) string {
+	folded, _ := FoldAccents(text)
+	for i, r := range folded {
+		folded[i] = unicode.ToLower(r)
+	}
+	return string(folded)
+}
+
+// Lower is how the text of documents and queries is lowered before comparing them
+// keepAccents only changes the case (see Normalize)
+func Lower(text string, keepAccents bool) string {
+	if keepAccents {
+		return strings.ToLower(text)
+	}
+	return Normalize(text)
+}
+
+// Fold is how case sensitive terms and the fields they are compared with are normalized:
+// accents are removed unless keepAccents and case is kept (see Lower)
+func Fold(text string, keepAccents bool) string {
+	if keepAccents {
+		return text
+	}
+	folded, _ := FoldAccents(text)
+	return string(folded)
+}
+
+// FoldText is FoldAccents unless keepAccents, then the runes of text are returned as they are
+func FoldText(text string, keepAccents bool) ([]rune, []int) {
+	if !keepAccents {
+		return FoldAccents(text)
+	}
+	folded := []rune(text)
+	origin := make([]int, len(folded))
+	for i := range origin {
+		origin[i] = i
+	}
+	return folded, origin
+}
+
+// FoldAccents decomposes every rune of text (NFKD) and drops the accents (non spacing marks), case is kept.
+// It also returns for each folded rune the position of the rune of text it comes from
+// so that what matched in the folded text can be highlighted in the original one
+func FoldAccents(text string) ([]rune, []int) {
+	folded := make([]rune, 0, len(text))
+	origin := make([]int, 0, len(text))
+	position := 0
+	for i, r := range text {
+		if r < utf8.RuneSelf {
+			folded = append(folded, r)
+			origin = append(origin, position)
+		} else if decomposition := norm.NFKD.PropertiesString(text[i:]).Decomposition(); decomposition != nil {
+			for _, d := range string(decomposition) {
+				if !unicode.Is(unicode.Mn, d) {
+					folded = append(folded, d)
+					origin = append(origin, position)
+				}
+			}
+		} else if !unicode.Is(unicode.Mn, r) {
+			folded = append(folded, r)
+			origin = append(origin, position)
+		}
+		position++
+	}
+	return folded, origin
+}
@@ -0,0 +1,73 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	cases := map[string]string{
+		"Café":     "cafe",
+		"ｆｎｄ":      "fnd",
+		"ﬁle":      "file",
+		"Ångström": "angstrom",
+		"naïve 東京": "naive 東京",
+		"🯱🯲":       "12",
+	}
+	for text, expected := range cases {
+		if got := Normalize(text); got != expected {
+			t.Errorf("Expected: '%v' but got '%v'", expected, got)
+		}
+	}
+}
+
+func TestFindAllIgnoresAccents(t *testing.T) {
+	cases := []struct {
+		text     string
+		query    string
+		expected []Range
+	}{
+		{"Café crème", "creme", []Range{{Start: 5, End: 10}}},
+		{"Café crème", "café", []Range{{Start: 0, End: 4}}},
+		{"ﬁle ﬁle", "fi", []Range{{Start: 0, End: 1}, {Start: 4, End: 5}}},
+		{"ﬁle", "ile", []Range{{Start: 0, End: 3}}},
+	}
+	for _, c := range cases {
+		if got := FindAll(c.text, c.query, false); !reflect.DeepEqual(c.expected, got) {
+			t.Errorf("%s in %s: Expected: '%v' but got '%v'", c.query, c.text, c.expected, got)
+		}
+	}
+	doc := ParseLine(PlainTextParser(), "Crème brûlée")
+	for query, expected := range map[string][]Range{"^creme": {{Start: 0, End: 5}}, "brulee$": {{Start: 6, End: 12}}} {
+		subQuery := ParseQuery(query)[0]
+		if !MatchesDocument(doc, subQuery) {
+			t.Errorf("Expected '%s' to match", query)
+		}
+		if got := FindMatches(doc.ParsedLine["$"], subQuery); !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", query, expected, got)
+		}
+	}
+}
+
+func TestKeepAccents(t *testing.T) {
+	subQueries, _ := QueryParser{KeepAccents: true}.Parse("'Café")
+	if subQueries[0].Query != "café" {
+		t.Errorf("Expected: '%v' but got '%v'", "café", subQueries[0].Query)
+	}
+	doc := ParseLine(PlainTextParser().KeepAccents(), "Café")
+	if doc.LoweredParsed["$"] != "café" {
+		t.Errorf("Expected: '%v' but got '%v'", "café", doc.LoweredParsed["$"])
+	}
+	if MatchesDocument(doc, ParseQuery("'cafe")[0]) {
+		t.Errorf("Expected 'cafe' not to match 'Café' when keeping accents")
+	}
+	if got := FindAll("Café cafe", "cafe", true); !reflect.DeepEqual([]Range{{Start: 5, End: 9}}, got) {
+		t.Errorf("Expected: '%v' but got '%v'", []Range{{Start: 5, End: 9}}, got)
+	}
+	if got := FindMatches("Café", subQueries[0]); !reflect.DeepEqual([]Range{{Start: 0, End: 4}}, got) {
+		t.Errorf("Expected: '%v' but got '%v'", []Range{{Start: 0, End: 4}}, got)
+	}
+	if got := FindMatches("Cafe", subQueries[0]); len(got) != 0 {
+		t.Errorf("Expected no matches but got '%v'", got)
+	}
+}
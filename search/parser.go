@@ -1,11 +1,14 @@
 package search
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"github.com/txominpelu/fnd/log"
-	"golang.org/x/exp/slices"
+	"math"
+	"math/big"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -13,12 +16,28 @@ import (
 type Parser struct {
 	headers []string
 	parse   func(string) map[string]interface{}
+	// keepAccents only lower cases the fields for searching instead of normalizing them (see Normalize)
+	keepAccents bool
+	// headerLine is true if the first line has the headers instead of a document
+	headerLine bool
 }
 
 func (p Parser) Headers() []string {
 	return p.headers
 }
 
+// HasHeaderLine tells if the first line is the header and not a document
+func (p Parser) HasHeaderLine() bool {
+	return p.headerLine
+}
+
+// KeepAccents returns a parser whose documents are searched without removing accents
+// and compatibility forms, only ignoring case
+func (p Parser) KeepAccents() Parser {
+	p.keepAccents = true
+	return p
+}
+
 func (p Parser) Parse() func(string) map[string]interface{} {
 	return p.parse
 }
@@ -43,43 +62,112 @@ func TabularParser(headers []string, delimiter rune) Parser {
 	}
 }
 
-func FormatNameToParser(format string, firstline string, headers []string, hideColumns []string, logger *log.StandardLogger, delimiter rune) Parser {
+// ParserOptions are the settings of the parser created by FormatNameToParser
+type ParserOptions struct {
+	// Headers are the columns to display, the ones found in the first line if empty
+	Headers []string
+	// HideColumns are not displayed, hiding a json object hides all its fields
+	HideColumns []string
+	// Delimiter between the columns of the tabular format
+	Delimiter rune
+	// FlattenArrays indexes the fields inside json arrays without the position of the element (see JsonParser)
+	FlattenArrays bool
+	// CsvHeader tells if the first line of the csv and tsv formats is a header
+	CsvHeader bool
+	// Pattern of the regex format, its named groups are the columns (see RegexParser)
+	Pattern string
+}
+
+// FormatSplitFunc returns how the input of a format is split in documents: by lines
+// or, for csv and tsv, by records that can have new lines inside quoted values
+func FormatSplitFunc(format string) bufio.SplitFunc {
+	switch format {
+	case "csv":
+		return ScanCsvRecords(',')
+	case "tsv":
+		return ScanCsvRecords('\t')
+	}
+	return bufio.ScanLines
+}
+
+// FormatNameToParser creates the parser of a format from the first lines of the input:
+// the first one has the headers (tabular, csv, columns) or the fields (json), for logfmt the headers are
+// the keys of all of them and for columns the rows tell where each column starts
+func FormatNameToParser(format string, firstLines []string, options ParserOptions, logger *log.StandardLogger) Parser {
+	firstline := ""
+	if len(firstLines) > 0 {
+		firstline = firstLines[0]
+	}
 	var p Parser
 	switch format {
 	case "plain":
 		p = PlainTextParser()
 	case "json":
-		m := map[string]interface{}{}
-		err := json.Unmarshal([]byte(firstline), &m)
+		m, err := decodeJson(firstline)
 		logger.CheckError(
 			err,
-			fmt.Sprintf("when parsing first line '' as json", firstline),
+			fmt.Sprintf("when parsing first line '%s' as json", firstline),
 		)
 		headers := []string{}
-		for k, _ := range m {
-			if !slices.Contains(hideColumns, k) {
+		for k, v := range flattenJson(m, options.FlattenArrays) {
+			if isJsonLeaf(v) && !isHidden(k, options.HideColumns) {
 				headers = append(headers, k)
 			}
 		}
 		sort.StringSlice(headers).Sort()
-		p = JsonParser(headers, logger)
+		p = JsonParser(headers, logger, options.FlattenArrays)
 	case "tabular":
-		headers := strings.FieldsFunc(firstline, func(r rune) bool { return r == delimiter })
+		headers := strings.FieldsFunc(firstline, func(r rune) bool { return r == options.Delimiter })
 		trimmedHeaders := []string{}
 		for _, h := range headers {
 			trimmedHeaders = append(trimmedHeaders, strings.TrimSpace(h))
 		}
-		p = TabularParser(trimmedHeaders, delimiter)
+		p = TabularParser(trimmedHeaders, options.Delimiter)
+		p.headerLine = true
+	case "csv", "tsv":
+		comma := ','
+		if format == "tsv" {
+			comma = '\t'
+		}
+		headers, err := csvHeaders(firstline, comma, options.CsvHeader)
+		logger.CheckError(err, fmt.Sprintf("when parsing first line '%s' as %s", firstline, format))
+		p = CsvParser(headers, comma, logger)
+		p.headerLine = options.CsvHeader
+	case "logfmt":
+		p = LogfmtParser(logfmtHeaders(firstLines))
+	case "regex":
+		pattern, err := CompilePattern(options.Pattern)
+		logger.CheckError(err, "when parsing pattern flag")
+		p = RegexParser(pattern)
+	case "grep":
+		p = GrepParser()
+	case "columns":
+		rows := []string{}
+		if len(firstLines) > 1 {
+			rows = firstLines[1:]
+		}
+		p = ColumnsParser(columnBoundaries(firstline, rows))
+		p.headerLine = true
 	default:
-		err := fmt.Errorf("pass invalid --line_format '%s' should be one of (plain/tabular/json) \n", format)
+		err := fmt.Errorf("pass invalid --line_format '%s' should be one of (plain/tabular/json/csv/tsv/logfmt/regex/grep/columns) \n", format)
 		logger.CheckError(err, "")
 	}
-	if len(headers) > 0 {
-		p.headers = headers
+	if len(options.Headers) > 0 {
+		p.headers = options.Headers
 	}
 	return p
 }
 
+// isHidden tells if the column or the json object that contains it is in hideColumns
+func isHidden(column string, hideColumns []string) bool {
+	for _, h := range hideColumns {
+		if column == h || strings.HasPrefix(column, h+".") || strings.HasPrefix(column, h+"[") {
+			return true
+		}
+	}
+	return false
+}
+
 func PlainTextParser() Parser {
 	parse := func(line string) map[string]interface{} { return map[string]interface{}{"$": line} }
 	return Parser{
@@ -88,12 +176,15 @@ func PlainTextParser() Parser {
 	}
 }
 
-func JsonParser(headers []string, logger *log.StandardLogger) Parser {
+// JsonParser makes every value of the document a field named by its path:
+// metadata.name for nested objects and spec.containers[0].image for arrays.
+// With flattenArrays the position is left out and the values of all the elements are joined
+// with spaces (spec.containers.image). Objects and arrays are fields too, as json.
+func JsonParser(headers []string, logger *log.StandardLogger, flattenArrays bool) Parser {
 	parse := func(line string) map[string]interface{} {
-		m := map[string]interface{}{}
-		err := json.Unmarshal([]byte(line), &m)
+		m, err := decodeJson(line)
 		logger.WarnIfErr(err, "when parsing line as json")
-		return m
+		return flattenJson(m, flattenArrays)
 	}
 	return Parser{
 		headers: headers,
@@ -101,31 +192,161 @@ func JsonParser(headers []string, logger *log.StandardLogger) Parser {
 	}
 }
 
+// decodeJson keeps the numbers as written (json.Number) instead of float64, see typedValue
+func decodeJson(line string) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	err := decoder.Decode(&m)
+	return m, err
+}
+
+// flattenJson returns the fields of a json document by path (see JsonParser)
+func flattenJson(document map[string]interface{}, flattenArrays bool) map[string]interface{} {
+	fields := map[string]interface{}{}
+	// values inside arrays when they are flattened, joined at the end
+	joined := map[string][]string{}
+	var add func(path string, value interface{}, inArray bool)
+	add = func(path string, value interface{}, inArray bool) {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if path != "" && !inArray {
+				fields[path] = v
+			}
+			for k, child := range v {
+				if path != "" {
+					k = path + "." + k
+				}
+				add(k, child, inArray)
+			}
+		case []interface{}:
+			if !inArray {
+				fields[path] = v
+			}
+			for i, child := range v {
+				if flattenArrays {
+					add(path, child, true)
+				} else {
+					add(fmt.Sprintf("%s[%d]", path, i), child, false)
+				}
+			}
+		default:
+			if inArray {
+				joined[path] = append(joined[path], formatValue(typedValue(v)))
+			} else {
+				fields[path] = v
+			}
+		}
+	}
+	add("", document, false)
+	for path, values := range joined {
+		fields[path] = strings.Join(values, " ")
+	}
+	return fields
+}
+
+// isJsonLeaf is false for objects and arrays, their fields are the columns
+func isJsonLeaf(value interface{}) bool {
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return true
+}
+
 func ParseLine(parser Parser, line string) Document {
 	m := parser.Parse()(line)
 	parsedLine := map[string]string{}
 	loweredParsed := map[string]string{}
+	values := map[string]interface{}{}
 	for k, interf := range m {
-		switch v := interf.(type) {
-		case int:
-			parsedLine[k] = fmt.Sprintf("%d", v)
-		case string:
-			parsedLine[k] = v
-		case map[string]any:
-			jsonStr, _ := json.Marshal(v)
-			msg := fmt.Sprintf("%s", jsonStr)
-			parsedLine[k] = msg
+		value := typedValue(interf)
+		parsedLine[k] = formatValue(value)
+		switch value.(type) {
+		// numbers kept as written are compared as the text of the field (see FieldNumber)
+		case string, json.Number:
 		default:
-			parsedLine[k] = fmt.Sprintf("%v", v)
+			values[k] = value
 		}
-		loweredParsed[k] = strings.ToLower(parsedLine[k])
+		loweredParsed[k] = Lower(parsedLine[k], parser.keepAccents)
 	}
 	parsedLine["$"] = line
-	loweredParsed["$"] = strings.ToLower(line)
+	loweredParsed["$"] = Lower(line, parser.keepAccents)
 	return Document{
 		RawText: line,
 		//only one level key, value
 		ParsedLine:    parsedLine,    //for display and return of command
 		LoweredParsed: loweredParsed, //for case insensitive search
+		Values:        values,        //for sorting, comparing and templates
+	}
+}
+
+// typedValue converts json numbers to int64 when they are integers (1e6 too) and float64 otherwise,
+// also inside objects and arrays. Numbers that would change as int64 or float64 (integers bigger than int64,
+// more digits than a float64 has) are kept as written. The rest of values are kept
+func typedValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			v[k] = typedValue(child)
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = typedValue(child)
+		}
+		return v
+	}
+	n, ok := value.(json.Number)
+	if !ok {
+		return value
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, err := n.Float64()
+	if err != nil || !exactFloat(n.String(), f) {
+		return n
+	}
+	// integers that can be represented exactly by a float64
+	if f == math.Trunc(f) && math.Abs(f) <= 1<<53 {
+		return int64(f)
+	}
+	return f
+}
+
+// exactFloat tells if f is the number written in text and not a rounding of it
+func exactFloat(text string, f float64) bool {
+	if f == 0 {
+		// 0.0 or -0e5 but not 1e-400
+		mantissa := strings.FieldsFunc(text, func(r rune) bool { return r == 'e' || r == 'E' })[0]
+		return strings.Trim(mantissa, "-+0.") == ""
+	}
+	written, okWritten := new(big.Rat).SetString(text)
+	shortest, okShortest := new(big.Rat).SetString(strconv.FormatFloat(f, 'g', -1, 64))
+	return okWritten && okShortest && written.Cmp(shortest) == 0
+}
+
+// formatValue is how a typed value is displayed and searched: numbers without exponent and null as in json
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case map[string]interface{}, []interface{}:
+		jsonStr, _ := json.Marshal(v)
+		return string(jsonStr)
 	}
+	return fmt.Sprintf("%v", value)
 }
@@ -0,0 +1,101 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+const podJson = `{"metadata": {"name": "web", "labels": {"app": "nginx"}}, "spec": {"containers": [{"image": "nginx:1.25"}, {"image": "redis"}]}, "tags": ["a", "b"]}`
+
+func TestNestedJsonFields(t *testing.T) {
+	parser := FormatNameToParser("json", []string{podJson}, ParserOptions{HideColumns: []string{"metadata.labels"}}, nil)
+	expectedHeaders := []string{"metadata.name", "spec.containers[0].image", "spec.containers[1].image", "tags[0]", "tags[1]"}
+	if !reflect.DeepEqual(expectedHeaders, parser.Headers()) {
+		t.Errorf("Expected: '%v' but got '%v'", expectedHeaders, parser.Headers())
+	}
+	doc := ParseLine(parser, podJson)
+	expected := map[string]string{
+		"metadata.name":            "web",
+		"metadata.labels.app":      "nginx",
+		"spec.containers[1].image": "redis",
+		"tags":                     `["a","b"]`,
+		"metadata.labels":          `{"app":"nginx"}`,
+	}
+	for field, value := range expected {
+		if doc.ParsedLine[field] != value {
+			t.Errorf("%s: Expected: '%v' but got '%v'", field, value, doc.ParsedLine[field])
+		}
+	}
+	if !MatchesDocument(doc, ParseQuery("spec.containers[0].image:^nginx")[0]) {
+		t.Errorf("Expected spec.containers[0].image to match")
+	}
+}
+
+func TestFlattenJsonArrays(t *testing.T) {
+	parser := FormatNameToParser("json", []string{podJson}, ParserOptions{FlattenArrays: true}, nil)
+	expectedHeaders := []string{"metadata.labels.app", "metadata.name", "spec.containers.image", "tags"}
+	if !reflect.DeepEqual(expectedHeaders, parser.Headers()) {
+		t.Errorf("Expected: '%v' but got '%v'", expectedHeaders, parser.Headers())
+	}
+	doc := ParseLine(parser, podJson)
+	expected := map[string]string{
+		"spec.containers.image": "nginx:1.25 redis",
+		"tags":                  "a b",
+	}
+	for field, value := range expected {
+		if doc.ParsedLine[field] != value {
+			t.Errorf("%s: Expected: '%v' but got '%v'", field, value, doc.ParsedLine[field])
+		}
+	}
+}
+
+func TestTypedJsonValues(t *testing.T) {
+	line := `{"count": 1084300, "big": 1.0843e+06, "ratio": 0.5, "active": true, "owner": null, "code": "42"}`
+	doc := ParseLine(JsonParser([]string{}, nil, false), line)
+	expectedText := map[string]string{"count": "1084300", "big": "1084300", "ratio": "0.5", "active": "true", "owner": "null", "code": "42"}
+	for field, value := range expectedText {
+		if doc.ParsedLine[field] != value {
+			t.Errorf("%s: Expected: '%v' but got '%v'", field, value, doc.ParsedLine[field])
+		}
+	}
+	expectedValues := map[string]interface{}{"count": int64(1084300), "big": int64(1084300), "ratio": 0.5, "active": true, "owner": nil}
+	if !reflect.DeepEqual(expectedValues, doc.Values) {
+		t.Errorf("Expected: '%v' but got '%v'", expectedValues, doc.Values)
+	}
+	cases := map[string]bool{
+		"count:>1000000": true,
+		"ratio:<1":       true,
+		"active:>0":      false,
+		"code:>10":       true,
+	}
+	for query, expected := range cases {
+		if got := MatchesDocument(doc, ParseQuery(query)[0]); got != expected {
+			t.Errorf("%s: Expected: '%v' but got '%v'", query, expected, got)
+		}
+	}
+}
+
+func TestInexactJsonNumbers(t *testing.T) {
+	line := `{"id": 12345678901234567890, "pi": 3.14159265358979323846, "tiny": 1e-400, "ratio": 0.10, "nested": {"id": 12345678901234567890, "n": 2}}`
+	doc := ParseLine(JsonParser([]string{}, nil, false), line)
+	expectedText := map[string]string{
+		"id":     "12345678901234567890",
+		"pi":     "3.14159265358979323846",
+		"tiny":   "1e-400",
+		"ratio":  "0.1",
+		"nested": `{"id":12345678901234567890,"n":2}`,
+	}
+	for field, value := range expectedText {
+		if doc.ParsedLine[field] != value {
+			t.Errorf("%s: Expected: '%v' but got '%v'", field, value, doc.ParsedLine[field])
+		}
+	}
+	for _, field := range []string{"id", "pi", "tiny"} {
+		if _, ok := doc.Values[field]; ok {
+			t.Errorf("%s: Expected no typed value but got '%v'", field, doc.Values[field])
+		}
+	}
+	if got := MatchesDocument(doc, ParseQuery("id:>1e19")[0]); !got {
+		t.Errorf("Expected: '%v' but got '%v'", true, got)
+	}
+}
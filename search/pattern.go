@@ -0,0 +1,67 @@
+package search
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RegexParser makes a column of each named group of the pattern: (?P<status>\d+).
+// Lines that don't match only have the whole line ($), groups that didn't match are left out
+func RegexParser(pattern *regexp.Regexp) Parser {
+	names := pattern.SubexpNames()
+	parse := func(line string) map[string]interface{} {
+		result := map[string]interface{}{}
+		match := pattern.FindStringSubmatchIndex(line)
+		if match == nil {
+			return result
+		}
+		for i, name := range names {
+			if name != "" && match[2*i] >= 0 {
+				result[name] = line[match[2*i]:match[2*i+1]]
+			}
+		}
+		return result
+	}
+	return Parser{
+		headers: patternHeaders(pattern),
+		parse:   parse,
+	}
+}
+
+// grepPattern matches file:line:content and file:line:col:content (rg --line-number --column --no-heading or --vimgrep, grep -n, compilers).
+// The file ends at the first :number: so that the content can have colons,
+// and it can start with a windows drive (C:\src\main.go)
+var grepPattern = regexp.MustCompile(`^(?P<file>(?:[A-Za-z]:[\\/])?.*?):(?P<line>\d+):(?:(?P<col>\d+):)?(?P<content>.*)$`)
+
+// GrepParser parses the output of grep like tools in the columns file, line, col (if any) and content
+func GrepParser() Parser {
+	return RegexParser(grepPattern)
+}
+
+// CompilePattern compiles the pattern of the regex format, it needs named groups to have columns
+func CompilePattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("the regex line format needs a pattern with named groups e.g '(?P<status>\\d+)'")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(patternHeaders(re)) == 0 {
+		return nil, fmt.Errorf("pattern '%s' has no named groups e.g '(?P<status>\\d+)'", pattern)
+	}
+	return re, nil
+}
+
+// patternHeaders returns the named groups in the order they appear in the pattern
+func patternHeaders(pattern *regexp.Regexp) []string {
+	headers := []string{}
+	seen := map[string]bool{}
+	for _, name := range pattern.SubexpNames() {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			headers = append(headers, name)
+		}
+	}
+	return headers
+}
@@ -0,0 +1,49 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegexParser(t *testing.T) {
+	options := ParserOptions{Pattern: `(?P<ip>\S+) \S+ \S+ \[(?P<time>[^\]]+)\] "(?P<req>[^"]*)" (?P<status>\d+)(?: (?P<bytes>\d+))?`}
+	parser := FormatNameToParser("regex", []string{}, options, nil)
+	expectedHeaders := []string{"ip", "time", "req", "status", "bytes"}
+	if !reflect.DeepEqual(expectedHeaders, parser.Headers()) {
+		t.Errorf("Expected: '%v' but got '%v'", expectedHeaders, parser.Headers())
+	}
+	line := `10.0.0.1 - - [10/Oct/2023:13:55:36 +0000] "GET /index.html HTTP/1.1" 404`
+	doc := ParseLine(parser, line)
+	expected := map[string]string{"$": line, "ip": "10.0.0.1", "time": "10/Oct/2023:13:55:36 +0000", "req": "GET /index.html HTTP/1.1", "status": "404"}
+	if !reflect.DeepEqual(expected, doc.ParsedLine) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, doc.ParsedLine)
+	}
+	notMatching := ParseLine(parser, "garbage")
+	if !reflect.DeepEqual(map[string]string{"$": "garbage"}, notMatching.ParsedLine) {
+		t.Errorf("Expected: '%v' but got '%v'", map[string]string{"$": "garbage"}, notMatching.ParsedLine)
+	}
+}
+
+func TestCompilePattern(t *testing.T) {
+	for _, pattern := range []string{"", `(\d+)`, `(?P<x>`} {
+		if _, err := CompilePattern(pattern); err == nil {
+			t.Errorf("Expected an error for '%s'", pattern)
+		}
+	}
+}
+
+func TestGrepParser(t *testing.T) {
+	parser := FormatNameToParser("grep", []string{}, ParserOptions{}, nil)
+	cases := map[string]map[string]string{
+		`cmd/root.go:12:5:	fmt.Printf("a:%d:b", 1)`: {"file": "cmd/root.go", "line": "12", "col": "5", "content": `	fmt.Printf("a:%d:b", 1)`},
+		`main.go:7:url := "http://x"`:               {"file": "main.go", "line": "7", "content": `url := "http://x"`},
+		`C:\src\main.go:3:1:x := 1`:                 {"file": `C:\src\main.go`, "line": "3", "col": "1", "content": "x := 1"},
+		`main.go:9:`:                                {"file": "main.go", "line": "9", "content": ""},
+	}
+	for line, expected := range cases {
+		expected["$"] = line
+		if got := ParseLine(parser, line).ParsedLine; !reflect.DeepEqual(expected, got) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", line, expected, got)
+		}
+	}
+}
@@ -1,31 +1,494 @@
 package search
 
-import "strings"
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// MatchKind tells how the text of a subquery is compared against a field
+type MatchKind int
+
+const (
+	// Fuzzy is the default, the meaning depends on the searcher
+	Fuzzy MatchKind = iota
+	// Exact 'term: the field contains term
+	Exact
+	// Prefix ^term: the field starts with term
+	Prefix
+	// Suffix term$: the field ends with term
+	Suffix
+	// Equal ^term$: the field is term
+	Equal
+	// Greater >n: the field is a number bigger than n
+	Greater
+	// GreaterOrEqual >=n
+	GreaterOrEqual
+	// Less <n
+	Less
+	// LessOrEqual <=n
+	LessOrEqual
+	// Between n..m: the field is a number between n and m (both included)
+	// either end can be omitted
+	Between
+	// Where ?expr: a SQL like expression (see CompileWhere) checked with Predicate
+	Where
+)
 
 type SubQuery struct {
 	Field string
 	Query string
+	Kind  MatchKind
+	// Pattern is the term as typed (case and operators preserved) without field and negation
+	Pattern string
+	// Negated !term: matches the documents that don't match term
+	Negated bool
+	// CaseSensitive terms are compared as typed against ParsedLine instead of LoweredParsed
+	CaseSensitive bool
+	// KeepAccents terms are only lowered, not normalized, as the fields they are compared with (see Lower)
+	KeepAccents bool
+	// Or alternatives (a | b), the subquery matches if itself or any of them match
+	Or []SubQuery
+	// Low and High are the numbers compared against for the numeric kinds
+	Low  float64
+	High float64
+	// Predicate decides if a document matches for the Where kind
+	Predicate Predicate
+}
+
+// Alternatives returns the subquery itself followed by its Or alternatives
+func (s SubQuery) Alternatives() []SubQuery {
+	self := s
+	self.Or = nil
+	return append([]SubQuery{self}, s.Or...)
+}
+
+// CaseMode tells when the terms of a query are case sensitive
+type CaseMode int
+
+const (
+	// CaseIgnore never
+	CaseIgnore CaseMode = iota
+	// CaseSmart only the terms with an upper case letter
+	CaseSmart
+	// CaseRespect always
+	CaseRespect
+)
+
+// QueryParser converts what the user types into subqueries
+type QueryParser struct {
+	// SQL parses every query as a WHERE expression,
+	// otherwise only the queries starting with '?' are
+	SQL bool
+	// KeepAccents only lower cases the terms instead of normalizing them (see Normalize).
+	// It should match the Parser of the documents
+	KeepAccents bool
+	Case        CaseMode
+	// Columns are the fields searched by '*:term', usually the displayed ones so that
+	// the keys and punctuation of the raw line ($) don't match. Only $ if empty
+	Columns []string
+	// AllFields searches the terms without field in all the Columns as if they were '*:term'
+	AllFields bool
+	// Regex terms are go regexps (see regex.RegexSearcher): they only have a field if what is before
+	// ':' is a column, so that \d+:\d\d or (?i:err) are patterns, and '\ ' is a space inside the pattern.
+	// Only ! (negation) and | (or) are fzf operators, ' is a literal quote and ^ $ are regexp anchors
+	Regex bool
+}
+
+// caseSensitive tells if a term is compared with its case.
+// As in ripgrep, smart case skips the rune after a backslash: \S or \D are not upper case letters
+func (p QueryParser) caseSensitive(term string) bool {
+	switch p.Case {
+	case CaseRespect:
+		return true
+	case CaseSmart:
+		escaped := false
+		for _, r := range term {
+			if !escaped && unicode.IsUpper(r) {
+				return true
+			}
+			escaped = !escaped && r == '\\'
+		}
+	}
+	return false
+}
+
+// Parse returns an error if the query is not valid (e.g an incomplete SQL expression)
+func (p QueryParser) Parse(query string) ([]SubQuery, error) {
+	if p.SQL || strings.HasPrefix(query, "?") {
+		expr := strings.TrimPrefix(query, "?")
+		if strings.TrimSpace(expr) == "" {
+			return []SubQuery{}, nil
+		}
+		predicate, err := CompileWhere(expr)
+		if err != nil {
+			return nil, err
+		}
+		return []SubQuery{{Field: "$", Query: expr, Kind: Where, Predicate: predicate}}, nil
+	}
+	return parseQuery(query, p), nil
 }
 
 //Converts a query string to a list of queries
-// they should all match (AND)
+// they should all match (AND) except if they are separated by '|' (OR)
+// each term supports fzf's extended search syntax: 'exact ^prefix suffix$ !negation
+// and can be restricted to a field (field:term), all the columns (*:term) or some of them ({a,b}:term)
+// terms are normalized as the documents (see Normalize)
 func ParseQuery(query string) []SubQuery {
+	return parseQuery(query, QueryParser{})
+}
+
+func parseQuery(query string, p QueryParser) []SubQuery {
 	subqueryStrings := strings.Split(query, " ")
+	if p.Regex {
+		subqueryStrings = splitEscapedSpaces(query)
+	}
 	subqueries := []SubQuery{}
+	or := false
 	for _, s := range subqueryStrings {
-		subQuery := SubQuery{
-			Field: "$",
-			Query: strings.ToLower(s),
+		if s == "|" {
+			or = len(subqueries) > 0
+			continue
 		}
-		fieldQuery := strings.Split(s, ":")
-		// if query is like field:query
-		if len(fieldQuery) > 1 {
-			subQuery.Field = fieldQuery[0]
-			subQuery.Query = strings.ToLower(fieldQuery[1])
+		subQuery := parseTerm(s, p)
+		if subQuery.Query == "" {
+			continue
 		}
-		if subQuery.Query != "" {
-			subqueries = append(subqueries, subQuery)
+		expanded := p.expandFields(subQuery)
+		if or {
+			last := &subqueries[len(subqueries)-1]
+			for _, e := range expanded {
+				last.Or = append(last.Or, e.Alternatives()...)
+			}
+			or = false
+		} else {
+			subqueries = append(subqueries, expanded...)
 		}
 	}
 	return subqueries
 }
+
+// splitEscapedSpaces splits the query on the spaces that are not escaped with a backslash
+// and unescapes the others: a\ b c -> "a b", "c"
+func splitEscapedSpaces(query string) []string {
+	terms := []string{}
+	term := strings.Builder{}
+	escaped := false
+	for _, r := range query {
+		switch {
+		case escaped && r == ' ':
+			term.WriteRune(r)
+		case escaped:
+			term.WriteRune('\\')
+			term.WriteRune(r)
+		case r == ' ':
+			terms = append(terms, term.String())
+			term.Reset()
+		case r != '\\':
+			term.WriteRune(r)
+		}
+		escaped = !escaped && r == '\\'
+	}
+	if escaped {
+		term.WriteRune('\\')
+	}
+	return append(terms, term.String())
+}
+
+// isField tells if a regex term starts with a field selector (see QueryParser.Regex)
+func (p QueryParser) isField(selector string) bool {
+	if selector == "$" || selector == "*" || (strings.HasPrefix(selector, "{") && strings.HasSuffix(selector, "}")) {
+		return true
+	}
+	for _, column := range p.Columns {
+		if column == selector {
+			return true
+		}
+	}
+	return false
+}
+
+// expandFields turns a term on several fields (*:term or {a,b}:term) into the same term for each field:
+// alternatives (any of the fields matches) or, if it's negated, a subquery per field (none of them matches)
+func (p QueryParser) expandFields(subQuery SubQuery) []SubQuery {
+	fields := p.fields(subQuery.Field)
+	if fields == nil {
+		return []SubQuery{subQuery}
+	}
+	perField := make([]SubQuery, len(fields))
+	for i, field := range fields {
+		perField[i] = subQuery
+		perField[i].Field = field
+	}
+	if subQuery.Negated {
+		return perField
+	}
+	first := perField[0]
+	first.Or = perField[1:]
+	return []SubQuery{first}
+}
+
+// fields returns the fields selected by * or {a,b}, nil if the selector is a single field
+func (p QueryParser) fields(selector string) []string {
+	if selector == "*" {
+		if len(p.Columns) == 0 {
+			return []string{"$"}
+		}
+		return p.Columns
+	}
+	if strings.HasPrefix(selector, "{") && strings.HasSuffix(selector, "}") {
+		fields := []string{}
+		for _, field := range strings.Split(selector[1:len(selector)-1], ",") {
+			if field = strings.TrimSpace(field); field != "" {
+				fields = append(fields, field)
+			}
+		}
+		if len(fields) > 0 {
+			return fields
+		}
+	}
+	return nil
+}
+
+// parseTerm parses [field:][!]['|^]term[$]
+// or, for a field, [field:][!]<op>number / [field:][!]number..number
+func parseTerm(s string, p QueryParser) SubQuery {
+	subQuery := SubQuery{
+		Field: "$",
+		Kind:  Fuzzy,
+	}
+	fieldQuery := strings.SplitN(s, ":", 2)
+	hasField := len(fieldQuery) > 1 && (!p.Regex || p.isField(fieldQuery[0]))
+	// if query is like field:query
+	if hasField {
+		subQuery.Field = fieldQuery[0]
+		s = fieldQuery[1]
+	} else if p.AllFields {
+		subQuery.Field = "*"
+	}
+	if strings.HasPrefix(s, "!") {
+		subQuery.Negated = true
+		s = s[1:]
+	}
+	subQuery.Pattern = s
+	if hasField && parseComparison(s, &subQuery) {
+		return subQuery
+	}
+	if strings.HasPrefix(s, "'") {
+		subQuery.Kind = Exact
+		s = s[1:]
+	} else if strings.HasPrefix(s, "^") {
+		subQuery.Kind = Prefix
+		s = s[1:]
+	}
+	if len(s) > 1 && strings.HasSuffix(s, "$") {
+		if subQuery.Kind == Prefix {
+			subQuery.Kind = Equal
+		} else {
+			subQuery.Kind = Suffix
+		}
+		s = s[:len(s)-1]
+	}
+	// as in fzf a negated term is never fuzzy
+	if subQuery.Negated && subQuery.Kind == Fuzzy {
+		subQuery.Kind = Exact
+	}
+	subQuery.CaseSensitive = p.caseSensitive(s)
+	subQuery.KeepAccents = p.KeepAccents
+	if subQuery.CaseSensitive {
+		subQuery.Query = Fold(s, p.KeepAccents)
+	} else {
+		subQuery.Query = Lower(s, p.KeepAccents)
+	}
+	return subQuery
+}
+
+var comparisonOperators = []struct {
+	operator string
+	kind     MatchKind
+}{
+	// longest first so that >= is not read as >
+	{">=", GreaterOrEqual},
+	{"<=", LessOrEqual},
+	{">", Greater},
+	{"<", Less},
+}
+
+// parseComparison fills the subquery if s is a numeric comparison
+// an operator without number yet (e.g 'PID:>') gives an empty query so that it's ignored
+func parseComparison(s string, subQuery *SubQuery) bool {
+	for _, op := range comparisonOperators {
+		if strings.HasPrefix(s, op.operator) {
+			number := strings.TrimPrefix(s, op.operator)
+			n, ok := ParseNumber(number)
+			if number != "" && !ok {
+				return false
+			}
+			subQuery.Kind = op.kind
+			subQuery.Low = n
+			subQuery.High = n
+			subQuery.Query = number
+			return true
+		}
+	}
+	if bounds := strings.SplitN(s, "..", 2); len(bounds) == 2 && s != ".." {
+		low, lowOk := ParseNumber(bounds[0])
+		high, highOk := ParseNumber(bounds[1])
+		if (bounds[0] != "" && !lowOk) || (bounds[1] != "" && !highOk) {
+			return false
+		}
+		if bounds[0] == "" {
+			low = math.Inf(-1)
+		}
+		if bounds[1] == "" {
+			high = math.Inf(1)
+		}
+		subQuery.Kind = Between
+		subQuery.Low = low
+		subQuery.High = high
+		subQuery.Query = s
+		return true
+	}
+	return false
+}
+
+// ParseNumber parses the value of a field as a number.
+// inf and nan are text, not numbers
+func ParseNumber(value string) (float64, bool) {
+	n, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	return n, err == nil && !math.IsInf(n, 0) && !math.IsNaN(n)
+}
+
+// FieldNumber returns the number in a field of the document: its typed value (see Document.Values)
+// or, for the fields that are only text, the text parsed as a number
+func FieldNumber(doc Document, field string) (float64, bool) {
+	if value, ok := doc.Values[field]; ok {
+		switch v := value.(type) {
+		case int64:
+			return float64(v), true
+		case int:
+			return float64(v), true
+		case float64:
+			return v, true
+		}
+		return 0, false
+	}
+	return ParseNumber(doc.ParsedLine[field])
+}
+
+// MatchesDocument tells if the document matches a non fuzzy subquery.
+// Negation and alternatives are left to the caller
+func MatchesDocument(doc Document, subQuery SubQuery) bool {
+	switch subQuery.Kind {
+	case Where:
+		return subQuery.Predicate(doc)
+	case Greater, GreaterOrEqual, Less, LessOrEqual, Between:
+		n, ok := FieldNumber(doc, subQuery.Field)
+		return ok && compareNumber(n, subQuery)
+	}
+	return MatchesText(FieldText(doc, subQuery), subQuery)
+}
+
+// MatchesAlternative tells if the document matches a non fuzzy alternative of a subquery, negation included.
+// A negated comparison (PID:!>10) still needs the field to be a number
+func MatchesAlternative(doc Document, alt SubQuery) bool {
+	if !alt.Negated {
+		return MatchesDocument(doc, alt)
+	}
+	switch alt.Kind {
+	case Greater, GreaterOrEqual, Less, LessOrEqual, Between:
+		if _, ok := FieldNumber(doc, alt.Field); !ok {
+			return false
+		}
+	}
+	return !MatchesDocument(doc, alt)
+}
+
+// FieldText returns the text of the field of the document that the subquery is compared with:
+// folded keeping the case for case sensitive subqueries (see Fold), lowered otherwise
+func FieldText(doc Document, subQuery SubQuery) string {
+	if subQuery.CaseSensitive {
+		return Fold(doc.ParsedLine[subQuery.Field], subQuery.KeepAccents)
+	}
+	return doc.LoweredParsed[subQuery.Field]
+}
+
+// MatchesText tells if the text (see FieldText) matches a non fuzzy subquery.
+// For numeric kinds the text is parsed as a number, if it isn't one it doesn't match.
+// Negation and alternatives are left to the caller
+func MatchesText(text string, subQuery SubQuery) bool {
+	switch subQuery.Kind {
+	case Greater, GreaterOrEqual, Less, LessOrEqual, Between:
+		n, ok := ParseNumber(text)
+		return ok && compareNumber(n, subQuery)
+	case Exact:
+		return strings.Contains(text, subQuery.Query)
+	case Prefix:
+		return strings.HasPrefix(text, subQuery.Query)
+	case Suffix:
+		return strings.HasSuffix(text, subQuery.Query)
+	case Equal:
+		return text == subQuery.Query
+	}
+	return false
+}
+
+func compareNumber(n float64, subQuery SubQuery) bool {
+	switch subQuery.Kind {
+	case Greater:
+		return n > subQuery.Low
+	case GreaterOrEqual:
+		return n >= subQuery.Low
+	case Less:
+		return n < subQuery.High
+	case LessOrEqual:
+		return n <= subQuery.High
+	case Between:
+		return subQuery.Low <= n && n <= subQuery.High
+	}
+	return false
+}
+
+// Key identifies the subqueries, two lists with the same key match the same documents
+func Key(subQueries []SubQuery) string {
+	b := strings.Builder{}
+	for _, s := range subQueries {
+		for j, alt := range s.Alternatives() {
+			if j > 0 {
+				b.WriteString("|")
+			}
+			fmt.Fprintf(&b, "%s:%d:%t:%t:%s:%s", alt.Field, alt.Kind, alt.Negated, alt.CaseSensitive, alt.Pattern, alt.Query)
+		}
+		b.WriteString("\x00")
+	}
+	return b.String()
+}
+
+// Narrows tells if every document matching next also matches previous
+// for the fzf syntax. This is true if next only adds subqueries or
+// adds characters at the end of fuzzy, exact and prefix terms
+func Narrows(previous []SubQuery, next []SubQuery) bool {
+	if len(next) < len(previous) {
+		return false
+	}
+	for i, p := range previous {
+		n := next[i]
+		if Key([]SubQuery{p}) == Key([]SubQuery{n}) {
+			continue
+		}
+		if p.Field != n.Field || p.Kind != n.Kind || p.CaseSensitive != n.CaseSensitive || p.Negated || n.Negated || len(p.Or) > 0 || len(n.Or) > 0 {
+			return false
+		}
+		switch p.Kind {
+		case Fuzzy, Exact, Prefix:
+			if !strings.HasPrefix(n.Query, p.Query) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
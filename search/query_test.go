@@ -0,0 +1,174 @@
+package search
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseExtendedQuery(t *testing.T) {
+	got := ParseQuery("'exact ^pre suf$ ^eq$ USER:!root a | b")
+	expected := []SubQuery{
+		{Field: "$", Query: "exact", Kind: Exact, Pattern: "'exact"},
+		{Field: "$", Query: "pre", Kind: Prefix, Pattern: "^pre"},
+		{Field: "$", Query: "suf", Kind: Suffix, Pattern: "suf$"},
+		{Field: "$", Query: "eq", Kind: Equal, Pattern: "^eq$"},
+		{Field: "USER", Query: "root", Kind: Exact, Negated: true, Pattern: "root"},
+		{Field: "$", Query: "a", Kind: Fuzzy, Pattern: "a", Or: []SubQuery{
+			{Field: "$", Query: "b", Kind: Fuzzy, Pattern: "b"},
+		}},
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, got)
+	}
+}
+
+func TestParseIncompleteQuery(t *testing.T) {
+	got := ParseQuery("! ^ | abc |")
+	expected := []SubQuery{
+		{Field: "$", Query: "abc", Kind: Fuzzy, Pattern: "abc"},
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, got)
+	}
+}
+
+func TestParseComparisons(t *testing.T) {
+	got := ParseQuery("%CPU:>10 PID:<=1000 RSS:!>=5e4 PID:100..200 PID:..5 PID:> a:>b")
+	expected := []SubQuery{
+		{Field: "%CPU", Query: "10", Kind: Greater, Pattern: ">10", Low: 10, High: 10},
+		{Field: "PID", Query: "1000", Kind: LessOrEqual, Pattern: "<=1000", Low: 1000, High: 1000},
+		{Field: "RSS", Query: "5e4", Kind: GreaterOrEqual, Negated: true, Pattern: ">=5e4", Low: 50000, High: 50000},
+		{Field: "PID", Query: "100..200", Kind: Between, Pattern: "100..200", Low: 100, High: 200},
+		{Field: "PID", Query: "..5", Kind: Between, Pattern: "..5", Low: math.Inf(-1), High: 5},
+		{Field: "a", Query: ">b", Kind: Fuzzy, Pattern: ">b"},
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, got)
+	}
+}
+
+func TestMatchesNumber(t *testing.T) {
+	subQuery := ParseQuery("PID:100..200")[0]
+	for text, expected := range map[string]bool{"100": true, " 150.5 ": true, "201": false, "abc": false, "": false} {
+		if got := MatchesText(text, subQuery); got != expected {
+			t.Errorf("'%s' expected: '%v' but got '%v'", text, expected, got)
+		}
+	}
+	for _, text := range []string{"inf", "+Inf", "NaN", "infinity"} {
+		if _, ok := ParseNumber(text); ok {
+			t.Errorf("'%s' expected not to be a number", text)
+		}
+	}
+}
+
+func TestNegatedComparisonNeedsNumber(t *testing.T) {
+	parser := TabularParser([]string{"PID"}, ' ')
+	negated := ParseQuery("PID:!>10")[0]
+	for line, expected := range map[string]bool{"5": true, "50": false, "abc": false, "inf": false} {
+		if got := MatchesAlternative(ParseLine(parser, line), negated); got != expected {
+			t.Errorf("'%s' expected: '%v' but got '%v'", line, expected, got)
+		}
+	}
+}
+
+func TestCaseModes(t *testing.T) {
+	doc := ParseLine(PlainTextParser(), "README.md")
+	cases := []struct {
+		mode    CaseMode
+		query   string
+		matches bool
+	}{
+		{CaseIgnore, "'Readme", true},
+		{CaseSmart, "'readme", true},
+		{CaseSmart, "'Readme", false},
+		{CaseSmart, "'README", true},
+		{CaseRespect, "'readme", false},
+		{CaseRespect, "'README", true},
+	}
+	for _, c := range cases {
+		subQueries, _ := QueryParser{Case: c.mode}.Parse(c.query)
+		if got := MatchesDocument(doc, subQueries[0]); got != c.matches {
+			t.Errorf("%s (%d): Expected: '%v' but got '%v'", c.query, c.mode, c.matches, got)
+		}
+	}
+}
+
+func TestSmartCaseSkipsEscapes(t *testing.T) {
+	cases := map[string]bool{
+		`\S+\.md`:  false,
+		`\D\W\B`:   false,
+		`\\Server`: true,
+		`R\d`:      true,
+	}
+	for query, expected := range cases {
+		subQueries, _ := QueryParser{Case: CaseSmart, Regex: true}.Parse(query)
+		if got := subQueries[0].CaseSensitive; got != expected {
+			t.Errorf("%s: Expected: '%v' but got '%v'", query, expected, got)
+		}
+	}
+}
+
+func TestParseFieldSelectors(t *testing.T) {
+	p := QueryParser{Columns: []string{"file", "content"}}
+	alternatives := func(sQ SubQuery) []string {
+		fields := []string{}
+		for _, alt := range sQ.Alternatives() {
+			fields = append(fields, alt.Field+":"+alt.Query)
+		}
+		return fields
+	}
+	cases := []struct {
+		query    string
+		expected [][]string
+	}{
+		{"*:main", [][]string{{"file:main", "content:main"}}},
+		{"{file,title}:main", [][]string{{"file:main", "title:main"}}},
+		{"*:!test", [][]string{{"file:test"}, {"content:test"}}},
+		{"main", [][]string{{"$:main"}}},
+		{"a | *:b", [][]string{{"$:a", "file:b", "content:b"}}},
+	}
+	for _, c := range cases {
+		subQueries, _ := p.Parse(c.query)
+		got := [][]string{}
+		for _, sQ := range subQueries {
+			got = append(got, alternatives(sQ))
+		}
+		if !reflect.DeepEqual(c.expected, got) {
+			t.Errorf("%s: Expected: '%v' but got '%v'", c.query, c.expected, got)
+		}
+	}
+	p.AllFields = true
+	subQueries, _ := p.Parse("main")
+	if got := alternatives(subQueries[0]); !reflect.DeepEqual([]string{"file:main", "content:main"}, got) {
+		t.Errorf("Expected: '%v' but got '%v'", []string{"file:main", "content:main"}, got)
+	}
+}
+
+func TestAllFieldsIgnoresJsonSyntax(t *testing.T) {
+	parser := JsonParser([]string{"file", "content"}, nil, false)
+	doc := ParseLine(parser, `{"file": "main.go", "content": "package main"}`)
+	p := QueryParser{Columns: parser.Headers()}
+	cases := map[string]bool{
+		"*:'main":            true,
+		"*:'file":            false,
+		"'file":              true,
+		"*:!'main":           false,
+		"*:!'test":           true,
+		"{content}:'main.go": false,
+	}
+	for query, expected := range cases {
+		subQueries, _ := p.Parse(query)
+		got := true
+		for _, sQ := range subQueries {
+			matchesAny := false
+			for _, alt := range sQ.Alternatives() {
+				matchesAny = matchesAny || MatchesDocument(doc, alt) != alt.Negated
+			}
+			got = got && matchesAny
+		}
+		if got != expected {
+			t.Errorf("%s: Expected: '%v' but got '%v'", query, expected, got)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package regex
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/txominpelu/fnd/search"
+)
+
+// RegexSearcher matches each subquery as a go regexp (RE2) against its field, ignoring accents as the other searchers.
+// Numeric comparisons and SQL queries are matched as in the other searchers.
+// The fzf operators ' ^ $ are part of the regexp (see search.QueryParser.Regex), only ! and | are not.
+// As FuzzySearcher it's safe for one goroutine adding documents while others query it.
+type RegexSearcher struct {
+	mutex sync.RWMutex
+	docs  []search.Document
+	// the regexps of the last query, MatchPositions is called for every rendered row
+	compileMutex sync.Mutex
+	compiledKey  string
+	compiled     [][]*regexp.Regexp
+	compileErr   error
+}
+
+func NewRegexSearcher() *RegexSearcher {
+	return &RegexSearcher{
+		docs: []search.Document{},
+	}
+}
+
+func (r *RegexSearcher) AddDocument(d search.Document) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.docs = append(r.docs, d)
+}
+
+// snapshot returns the documents added so far, docId = index in the slice
+func (r *RegexSearcher) snapshot() []search.Document {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.docs
+}
+
+func (r *RegexSearcher) Count() int {
+	return len(r.snapshot())
+}
+
+func (r *RegexSearcher) GetDocById(docId int) search.Document {
+	return r.snapshot()[docId]
+}
+
+// Validate returns the error of the first subquery that is not a valid regexp
+func (r *RegexSearcher) Validate(subQueries []search.SubQuery) error {
+	_, err := r.compile(subQueries)
+	return err
+}
+
+// cancelCheckInterval is the number of documents filtered between checks of the context
+const cancelCheckInterval = 1024
+
+// FilterEntries returns no docs if any of the regexps is invalid
+func (r *RegexSearcher) FilterEntries(subQueries []search.SubQuery) []int {
+	results, err := r.FilterEntriesContext(context.Background(), subQueries)
+	if err != nil {
+		return []int{}
+	}
+	return results
+}
+
+// FilterEntriesContext returns the error of the first invalid regexp
+// or ctx's error if ctx is done before all the documents are filtered
+func (r *RegexSearcher) FilterEntriesContext(ctx context.Context, subQueries []search.SubQuery) ([]int, error) {
+	compiled, err := r.compile(subQueries)
+	if err != nil {
+		return nil, err
+	}
+	docs := r.snapshot()
+	results := make([]int, len(docs))
+	for i := range docs {
+		results[i] = i
+	}
+	for i, subQ := range subQueries {
+		results, err = filter(ctx, docs, results, subQ, compiled[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	// if no query all docs match
+	return results, nil
+}
+
+// MatchPositions returns every match of the regexps in their field
+func (r *RegexSearcher) MatchPositions(doc search.Document, subQueries []search.SubQuery) search.MatchPositions {
+	positions := search.MatchPositions{}
+	compiled, err := r.compile(subQueries)
+	if err != nil {
+		return positions
+	}
+	for i, subQ := range subQueries {
+		for j, alt := range subQ.Alternatives() {
+			re := compiled[i][j]
+			if re == nil || alt.Negated {
+				continue
+			}
+			folded, origin := search.FoldText(doc.ParsedLine[alt.Field], alt.KeepAccents)
+			text := string(folded)
+			for _, loc := range re.FindAllStringIndex(text, -1) {
+				// regexp gives byte offsets of the folded text, highlighting works with runes of the original one
+				start := utf8.RuneCountInString(text[:loc[0]])
+				end := start + utf8.RuneCountInString(text[loc[0]:loc[1]])
+				if end > start {
+					positions[alt.Field] = append(positions[alt.Field], search.OriginalRange(origin, start, end))
+				}
+			}
+		}
+	}
+	return positions
+}
+
+func filter(ctx context.Context, docs []search.Document, docIds []int, subQuery search.SubQuery, compiled []*regexp.Regexp) ([]int, error) {
+	result := []int{}
+	for i, docId := range docIds {
+		if i%cancelCheckInterval == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		doc := docs[docId]
+		for j, alt := range subQuery.Alternatives() {
+			var ok bool
+			if compiled[j] != nil {
+				ok = compiled[j].MatchString(search.Fold(doc.ParsedLine[alt.Field], alt.KeepAccents)) != alt.Negated
+			} else {
+				ok = search.MatchesAlternative(doc, alt)
+			}
+			if ok {
+				result = append(result, docId)
+				break
+			}
+		}
+	}
+	return result, nil
+}
+
+// compile returns compileAll of the subqueries reusing the result of the previous call if it had the same key
+func (r *RegexSearcher) compile(subQueries []search.SubQuery) ([][]*regexp.Regexp, error) {
+	key := search.Key(subQueries)
+	r.compileMutex.Lock()
+	defer r.compileMutex.Unlock()
+	if (r.compiled == nil && r.compileErr == nil) || r.compiledKey != key {
+		r.compiled, r.compileErr = compileAll(subQueries)
+		r.compiledKey = key
+	}
+	return r.compiled, r.compileErr
+}
+
+// compileAll compiles every alternative of every subquery without accents unless KeepAccents (see search.Fold),
+// they are matched against the fields folded in the same way.
+// The alternatives that are not text (numeric comparisons, sql) are left nil
+func compileAll(subQueries []search.SubQuery) ([][]*regexp.Regexp, error) {
+	compiled := make([][]*regexp.Regexp, len(subQueries))
+	for i, subQ := range subQueries {
+		for _, alt := range subQ.Alternatives() {
+			var re *regexp.Regexp
+			if isText(alt) {
+				var err error
+				flags := "(?i)"
+				if alt.CaseSensitive {
+					flags = ""
+				}
+				re, err = regexp.Compile(flags + search.Fold(alt.Pattern, alt.KeepAccents))
+				if err != nil {
+					return nil, err
+				}
+			}
+			compiled[i] = append(compiled[i], re)
+		}
+	}
+	return compiled, nil
+}
+
+func isText(subQuery search.SubQuery) bool {
+	switch subQuery.Kind {
+	case search.Fuzzy, search.Exact, search.Prefix, search.Suffix, search.Equal:
+		return true
+	}
+	return false
+}
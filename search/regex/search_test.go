@@ -0,0 +1,163 @@
+package regex
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/txominpelu/fnd/search"
+)
+
+func TestRegex(t *testing.T) {
+	regexSearcher := NewRegexSearcher()
+	lines := []string{
+		`127.0.0.1 "GET /" 200`,
+		`127.0.0.1 "GET /missing" 404`,
+		`10.0.0.2 "POST /api" 503`,
+		`10.0.0.2 "GET /api" 500`,
+	}
+	for _, l := range lines {
+		regexSearcher.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	cases := map[string][]string{
+		`5\d\d$`:          {`10.0.0.2 "POST /api" 503`, `10.0.0.2 "GET /api" 500`},
+		`^127 !404`:       {`127.0.0.1 "GET /" 200`},
+		`post | \s404$`:   {`127.0.0.1 "GET /missing" 404`, `10.0.0.2 "POST /api" 503`},
+		`GET.*api 50[03]`: {`10.0.0.2 "GET /api" 500`},
+	}
+	for query, expected := range cases {
+		gotDocs := search.SortDocuments(
+			regexSearcher.FilterEntries(search.ParseQuery(query)),
+			regexSearcher,
+			func(d1 int, d2 int) bool { return d1 < d2 },
+		)
+		got := make([]string, len(gotDocs))
+		for i, d := range gotDocs {
+			got[i] = d.RawText
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Query '%s' expected: '%v' but got '%v'", query, expected, got)
+		}
+	}
+}
+
+func TestInvalidRegex(t *testing.T) {
+	regexSearcher := NewRegexSearcher()
+	regexSearcher.AddDocument(search.ParseLine(search.PlainTextParser(), "a(b"))
+	subQueries := search.ParseQuery("a(")
+	if err := regexSearcher.Validate(subQueries); err == nil {
+		t.Errorf("Expected an error for an invalid regexp")
+	}
+	if got := regexSearcher.FilterEntries(subQueries); len(got) != 0 {
+		t.Errorf("Expected no results but got '%v'", got)
+	}
+}
+
+func TestMatchPositions(t *testing.T) {
+	regexSearcher := NewRegexSearcher()
+	doc := search.ParseLine(search.PlainTextParser(), "héllo 503 404")
+	regexSearcher.AddDocument(doc)
+	expected := search.MatchPositions{
+		"$": []search.Range{{Start: 6, End: 9}, {Start: 10, End: 13}},
+	}
+	got := regexSearcher.MatchPositions(doc, search.ParseQuery(`\d+`))
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, got)
+	}
+}
+
+func TestRegexFields(t *testing.T) {
+	regexSearcher := NewRegexSearcher()
+	parser := search.LogfmtParser([]string{"time", "status", "msg"})
+	lines := []string{
+		`time=10:42:07 status=503 msg="ERR upstream timeout"`,
+		`time=10:43:11 status=200 msg="request done"`,
+		`time=11:02:55 status=404 msg="not found: /api"`,
+	}
+	for _, l := range lines {
+		regexSearcher.AddDocument(search.ParseLine(parser, l))
+	}
+	queryParser := search.QueryParser{Columns: parser.Headers(), Regex: true}
+	cases := map[string][]string{
+		`10:4\d:\d\d`:        {lines[0], lines[1]},
+		`(?i:err)`:           {lines[0]},
+		`status:5\d\d`:       {lines[0]},
+		`msg:found:\ /api$`:  {lines[2]},
+		`request\ done`:      {lines[1]},
+		`{status,msg}:^4|^r`: {lines[1], lines[2]},
+	}
+	for query, expected := range cases {
+		subQueries, err := queryParser.Parse(query)
+		if err != nil {
+			t.Errorf("Query '%s' unexpected error: '%v'", query, err)
+			continue
+		}
+		got := []string{}
+		for _, d := range regexSearcher.FilterEntries(subQueries) {
+			got = append(got, regexSearcher.GetDocById(d).RawText)
+		}
+		if !reflect.DeepEqual(expected, got) {
+			t.Errorf("Query '%s' expected: '%v' but got '%v'", query, expected, got)
+		}
+	}
+}
+
+func TestCompileCache(t *testing.T) {
+	regexSearcher := NewRegexSearcher()
+	first, _ := regexSearcher.compile(search.ParseQuery(`\d+ a`))
+	second, _ := regexSearcher.compile(search.ParseQuery(`\d+ a`))
+	if first[0][0] != second[0][0] {
+		t.Errorf("Expected the regexps of the same query to be reused")
+	}
+	third, _ := regexSearcher.compile(search.ParseQuery(`\d+ b`))
+	if third[1][0].String() != "(?i)b" {
+		t.Errorf("Expected: '%v' but got '%v'", "(?i)b", third[1][0].String())
+	}
+}
+
+func TestRegexIgnoresAccents(t *testing.T) {
+	regexSearcher := NewRegexSearcher()
+	for _, l := range []string{"Café crème", "cafe au lait", "tea"} {
+		regexSearcher.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	cases := map[string][]int{
+		`caf[eé]\b`: {0, 1},
+		`cafe`:      {0, 1},
+		`crème$`:    {0},
+		`!café`:     {2},
+	}
+	for query, expected := range cases {
+		if got := regexSearcher.FilterEntries(search.ParseQuery(query)); !reflect.DeepEqual(expected, got) {
+			t.Errorf("Query '%s' expected: '%v' but got '%v'", query, expected, got)
+		}
+	}
+	expected := search.MatchPositions{"$": []search.Range{{Start: 0, End: 4}, {Start: 5, End: 10}}}
+	if got := regexSearcher.MatchPositions(regexSearcher.GetDocById(0), search.ParseQuery(`c\w+`)); !reflect.DeepEqual(expected, got) {
+		t.Errorf("Expected: '%v' but got '%v'", expected, got)
+	}
+	keepAccents, _ := search.QueryParser{KeepAccents: true, Regex: true}.Parse("cafe")
+	if got := regexSearcher.FilterEntries(keepAccents); !reflect.DeepEqual([]int{1}, got) {
+		t.Errorf("Expected: '%v' but got '%v'", []int{1}, got)
+	}
+}
+
+func TestRegexKeepsFzfOperators(t *testing.T) {
+	regexSearcher := NewRegexSearcher()
+	for _, l := range []string{`GET /api`, `'GET' /api/v2`, `POST /api$`} {
+		regexSearcher.AddDocument(search.ParseLine(search.PlainTextParser(), l))
+	}
+	queryParser := search.QueryParser{Regex: true}
+	cases := map[string][]int{
+		`'GET`:           {1},
+		`^GET`:           {0},
+		`api$`:           {0},
+		`api\$$`:         {2},
+		`!'get | ^post`:  {0, 2},
+		`^'GET'\ /api/.`: {1},
+	}
+	for query, expected := range cases {
+		subQueries, _ := queryParser.Parse(query)
+		if got := regexSearcher.FilterEntries(subQueries); !reflect.DeepEqual(expected, got) {
+			t.Errorf("Query '%s' expected: '%v' but got '%v'", query, expected, got)
+		}
+	}
+}
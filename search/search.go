@@ -1,10 +1,14 @@
 package search
 
+import "context"
+
 type TextSearcher interface {
 	AddDocument(document Document)
 	// Given a bunch of subqueries returns the docIds that match them
 	FilterEntries(subQueries []SubQuery) []int
 	GetDocById(docId int) Document
+	// Given a document that matched the subqueries returns which parts of its fields matched
+	MatchPositions(document Document, subQueries []SubQuery) MatchPositions
 	Count() int
 }
 
@@ -12,4 +16,49 @@ type Document struct {
 	RawText       string
 	ParsedLine    map[string]string
 	LoweredParsed map[string]string
+	// Values are the typed values of the fields that are not text: numbers (int64 or float64,
+	// the ones they can't represent stay text), booleans, nulls, objects and arrays of formats like json
+	Values map[string]interface{}
+}
+
+// Scorer is implemented by searchers that can rank how well a document matches a query
+type Scorer interface {
+	// Score returns a higher value the better docId matches the subqueries
+	Score(docId int, subQueries []SubQuery) float64
+}
+
+// Ranker is implemented by searchers that can tell how relevant a document is for a query (e.g BM25)
+type Ranker interface {
+	// Relevance prepares the subqueries once and returns the relevance of each document, higher is better
+	Relevance(subQueries []SubQuery) func(docId int) float64
+}
+
+// QueryValidator is implemented by searchers that can reject a query (e.g an invalid regex)
+type QueryValidator interface {
+	Validate(subQueries []SubQuery) error
+}
+
+// Refiner is implemented by searchers that can filter only some of their documents
+// e.g the results of a previous query when the user keeps typing
+type Refiner interface {
+	// FilterCandidates returns the candidates that match the subqueries
+	// it stops with ctx's error when ctx is done
+	FilterCandidates(ctx context.Context, candidates []int, subQueries []SubQuery) ([]int, error)
+	// Narrows tells if every document that matches next also matches previous
+	Narrows(previous []SubQuery, next []SubQuery) bool
+}
+
+// CancellableSearcher is implemented by searchers that can stop filtering
+// when the results are not needed anymore (e.g the user kept typing)
+type CancellableSearcher interface {
+	FilterEntriesContext(ctx context.Context, subQueries []SubQuery) ([]int, error)
+}
+
+// FilterEntriesContext filters with the searcher, stopping as soon as ctx is done if it's a CancellableSearcher
+func FilterEntriesContext(ctx context.Context, searcher TextSearcher, subQueries []SubQuery) ([]int, error) {
+	if cancellable, ok := searcher.(CancellableSearcher); ok {
+		return cancellable.FilterEntriesContext(ctx, subQueries)
+	}
+	results := searcher.FilterEntries(subQueries)
+	return results, ctx.Err()
 }
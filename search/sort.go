@@ -4,6 +4,16 @@ import "sort"
 
 type Compare = func(int, int) bool
 
+// Sorter creates the Compare used to order the results of the given query
+type Sorter = func(subQueries []SubQuery) Compare
+
+// StaticSorter returns a Sorter that orders by the same Compare whatever the query
+func StaticSorter(by Compare) Sorter {
+	return func(subQueries []SubQuery) Compare {
+		return by
+	}
+}
+
 type docSorter struct {
 	docIds []int
 	by     func(d1, d2 int) bool // Closure used in the Less method.
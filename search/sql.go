@@ -0,0 +1,354 @@
+package search
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Predicate tells whether a document should be part of the results
+type Predicate = func(Document) bool
+
+// CompileWhere compiles a SQL like WHERE expression into a predicate over documents.
+// e.g: USER = 'root' AND (%CPU > 10 OR COMMAND LIKE '%java%') AND NOT name ~ '^k8s'
+//
+// Operands are columns (bare or "double quoted") or literals ('single quoted' strings and numbers).
+// Operators: = != <> < <= > >= LIKE (with % and _ wildcards, ignores case) ~ and REGEXP (go regexp).
+// When both sides of a comparison are numbers they are compared as numbers, otherwise as text.
+func CompileWhere(expr string) (Predicate, error) {
+	tokens, err := tokenizeWhere(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &whereParser{tokens: tokens}
+	predicate, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("unexpected '%s'", p.peek().text)
+	}
+	return predicate, nil
+}
+
+type whereTokenKind int
+
+const (
+	tokenColumn whereTokenKind = iota
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenKeyword
+	tokenOpenParen
+	tokenCloseParen
+)
+
+type whereToken struct {
+	kind whereTokenKind
+	text string
+}
+
+var whereKeywords = []string{"AND", "OR", "NOT", "LIKE", "REGEXP"}
+
+func tokenizeWhere(expr string) ([]whereToken, error) {
+	tokens := []whereToken{}
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, whereToken{tokenOpenParen, "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, whereToken{tokenCloseParen, ")"})
+			i++
+		case r == '\'' || r == '"' || r == '`':
+			// '' inside a quoted text is an escaped quote
+			text := strings.Builder{}
+			j := i + 1
+			for ; j < len(runes); j++ {
+				if runes[j] == r {
+					if j+1 < len(runes) && runes[j+1] == r {
+						j++
+					} else {
+						break
+					}
+				}
+				text.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("missing closing %c", r)
+			}
+			kind := tokenColumn
+			if r == '\'' {
+				kind = tokenString
+			}
+			tokens = append(tokens, whereToken{kind, text.String()})
+			i = j + 1
+		case strings.ContainsRune("=!<>~", r):
+			op := string(r)
+			if i+1 < len(runes) {
+				switch two := string(runes[i : i+2]); two {
+				case "!=", "<>", "<=", ">=":
+					op = two
+				}
+			}
+			if op == "!" {
+				return nil, fmt.Errorf("unknown operator '!'")
+			}
+			tokens = append(tokens, whereToken{tokenOperator, op})
+			i += len(op)
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune("()'\"`=!<>~", runes[j]) {
+				j++
+			}
+			word := string(runes[i:j])
+			tokens = append(tokens, classifyWord(word))
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+func classifyWord(word string) whereToken {
+	for _, k := range whereKeywords {
+		if strings.EqualFold(word, k) {
+			return whereToken{tokenKeyword, k}
+		}
+	}
+	if _, ok := ParseNumber(word); ok {
+		return whereToken{tokenNumber, word}
+	}
+	return whereToken{tokenColumn, word}
+}
+
+type whereParser struct {
+	tokens []whereToken
+	pos    int
+}
+
+func (p *whereParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *whereParser) peek() whereToken {
+	return p.tokens[p.pos]
+}
+
+func (p *whereParser) isKeyword(keyword string) bool {
+	return !p.done() && p.peek().kind == tokenKeyword && p.peek().text == keyword
+}
+
+// or := and (OR and)*
+func (p *whereParser) parseOr() (Predicate, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("OR") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(d Document) bool { return l(d) || right(d) }
+	}
+	return left, nil
+}
+
+// and := not (AND not)*
+func (p *whereParser) parseAnd() (Predicate, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("AND") {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(d Document) bool { return l(d) && right(d) }
+	}
+	return left, nil
+}
+
+// not := NOT not | '(' or ')' | comparison
+func (p *whereParser) parseNot() (Predicate, error) {
+	if p.isKeyword("NOT") {
+		p.pos++
+		inner, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return func(d Document) bool { return !inner(d) }, nil
+	}
+	if !p.done() && p.peek().kind == tokenOpenParen {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.done() || p.peek().kind != tokenCloseParen {
+			return nil, fmt.Errorf("missing ')'")
+		}
+		p.pos++
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+// comparison := operand op operand
+func (p *whereParser) parseComparison() (Predicate, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	negated := false
+	if p.isKeyword("NOT") {
+		negated = true
+		p.pos++
+	}
+	if p.done() || (p.peek().kind != tokenOperator && p.peek().kind != tokenKeyword) {
+		return nil, fmt.Errorf("expected an operator after '%s'", left.text)
+	}
+	op := p.peek().text
+	p.pos++
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	predicate, err := comparison(left, op, right)
+	if err != nil {
+		return nil, err
+	}
+	if negated {
+		if op != "LIKE" && op != "REGEXP" {
+			return nil, fmt.Errorf("NOT can't be used before '%s'", op)
+		}
+		return func(d Document) bool { return !predicate(d) }, nil
+	}
+	return predicate, nil
+}
+
+func (p *whereParser) parseOperand() (whereToken, error) {
+	if p.done() {
+		return whereToken{}, fmt.Errorf("unexpected end of query")
+	}
+	t := p.peek()
+	if t.kind != tokenColumn && t.kind != tokenString && t.kind != tokenNumber {
+		return whereToken{}, fmt.Errorf("unexpected '%s'", t.text)
+	}
+	p.pos++
+	return t, nil
+}
+
+// value returns the text of the operand for the given document
+func value(operand whereToken, d Document) string {
+	if operand.kind == tokenColumn {
+		return d.ParsedLine[operand.text]
+	}
+	return operand.text
+}
+
+// number returns the operand as a number for the given document (see FieldNumber)
+func number(operand whereToken, d Document) (float64, bool) {
+	if operand.kind == tokenColumn {
+		return FieldNumber(d, operand.text)
+	}
+	return ParseNumber(operand.text)
+}
+
+func comparison(left whereToken, op string, right whereToken) (Predicate, error) {
+	switch op {
+	case "LIKE":
+		return matchRegexp(left, right, likeToRegexp)
+	case "~", "REGEXP":
+		return matchRegexp(left, right, func(s string) string { return s })
+	case "=", "!=", "<>":
+		return func(d Document) bool {
+			return compare(left, op, right, d)
+		}, nil
+	case "<", "<=", ">", ">=":
+		// against a number, rows that aren't numeric don't match
+		numeric := left.kind == tokenNumber || right.kind == tokenNumber
+		return func(d Document) bool {
+			if numeric {
+				_, lOk := number(left, d)
+				_, rOk := number(right, d)
+				if !lOk || !rOk {
+					return false
+				}
+			}
+			return compare(left, op, right, d)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown operator '%s'", op)
+}
+
+// the pattern is compiled once so it has to be a literal
+func matchRegexp(left whereToken, right whereToken, toRegexp func(string) string) (Predicate, error) {
+	if right.kind == tokenColumn {
+		return nil, fmt.Errorf("pattern should be a quoted text, got column '%s'", right.text)
+	}
+	re, err := regexp.Compile(toRegexp(right.text))
+	if err != nil {
+		return nil, err
+	}
+	return func(d Document) bool {
+		return re.MatchString(value(left, d))
+	}, nil
+}
+
+func likeToRegexp(pattern string) string {
+	re := strings.Builder{}
+	re.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			re.WriteString(".*")
+		case '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	re.WriteString("$")
+	return re.String()
+}
+
+func compare(left whereToken, op string, right whereToken, d Document) bool {
+	c := 0
+	l, lOk := number(left, d)
+	r, rOk := number(right, d)
+	if lOk && rOk {
+		if l < r {
+			c = -1
+		} else if l > r {
+			c = 1
+		}
+	} else {
+		c = strings.Compare(value(left, d), value(right, d))
+	}
+	switch op {
+	case "=":
+		return c == 0
+	case "!=", "<>":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
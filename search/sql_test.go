@@ -0,0 +1,66 @@
+package search
+
+import (
+	"testing"
+)
+
+func TestCompileWhere(t *testing.T) {
+	parser := TabularParser([]string{"USER", "PID", "%CPU", "COMMAND"}, ' ')
+	docs := []Document{
+		ParseLine(parser, "root 1 0.0 /sbin/init"),
+		ParseLine(parser, "me 1234 12.5 java -jar app.jar"),
+		ParseLine(parser, "me 99 n/a defunct"),
+	}
+	cases := map[string][]bool{
+		"USER = 'root'":                               {true, false, false},
+		"USER != 'root' AND %CPU > 10":                {false, true, false},
+		"%CPU >= 0 AND NOT (PID < 100 OR PID > 1000)": {false, false, false},
+		"COMMAND LIKE '%JAVA%'":                       {false, true, false},
+		"COMMAND NOT LIKE '/sbin/%' and PID <= 99":    {false, false, true},
+		"COMMAND ~ '^(def|/s)'":                       {true, false, true},
+		"\"USER\" = 'me' or PID = 1":                  {true, true, true},
+		"$ REGEXP 'n/a'":                              {false, false, true},
+	}
+	for expr, expected := range cases {
+		predicate, err := CompileWhere(expr)
+		if err != nil {
+			t.Errorf("'%s' unexpected error: %s", expr, err)
+			continue
+		}
+		for i, d := range docs {
+			if got := predicate(d); got != expected[i] {
+				t.Errorf("'%s' on '%s' expected: '%v' but got '%v'", expr, d.RawText, expected[i], got)
+			}
+		}
+	}
+}
+
+func TestCompileWhereErrors(t *testing.T) {
+	for _, expr := range []string{
+		"USER = 'ro",
+		"USER =",
+		"(USER = 'root'",
+		"USER 'root'",
+		"COMMAND ~ '('",
+		"USER = 'root' PID",
+	} {
+		if _, err := CompileWhere(expr); err == nil {
+			t.Errorf("'%s' expected an error", expr)
+		}
+	}
+}
+
+func TestQueryParserSQL(t *testing.T) {
+	for query, expectedKind := range map[string]MatchKind{
+		"?USER = 'root'": Where,
+		"USER:root":      Fuzzy,
+	} {
+		subQueries, err := QueryParser{}.Parse(query)
+		if err != nil || len(subQueries) != 1 || subQueries[0].Kind != expectedKind {
+			t.Errorf("'%s' expected kind '%v' but got '%v' (error: %v)", query, expectedKind, subQueries, err)
+		}
+	}
+	if _, err := (QueryParser{SQL: true}).Parse("USER:root"); err == nil {
+		t.Errorf("expected an error when parsing fzf query in sql mode")
+	}
+}